@@ -4,7 +4,6 @@ import (
 	"go-serverci/internal"
 	"log/slog"
 	"os"
-	"time"
 
 	"github.com/alecthomas/kong"
 )
@@ -14,41 +13,13 @@ func main() {
 	ctx := kong.Parse(&cli,
 		kong.Name(internal.APP_NAME),
 		kong.Description("Render LaTeX from YAML + template, or run an HTTP server."),
+		kong.UsageOnError(),
 	)
 
-	fileMode := cli.YAML != "" || cli.Template != ""
-	if cli.Serve && fileMode {
-		ctx.Errorf("'-serve' cannot be used together with -yaml/-template flags")
-		os.Exit(2)
-	}
-	if !cli.Serve && !fileMode {
-		ctx.Errorf("Either use '-serve' OR provide both -yaml and -template")
-		ctx.PrintUsage(false)
-		os.Exit(2)
-	}
-
-	if !cli.Serve {
-		if cli.YAML == "" || cli.Template == "" {
-			ctx.Errorf("File mode requires both -yaml and -template")
-			os.Exit(2)
-		}
-		if err := internal.RunFileMode(cli); err != nil {
-			slog.Error(
-				"error running file mode",
-				"error", err,
-			)
-			os.Exit(1)
-		}
-		return
-	}
-
-	if cli.TexOut != "" || cli.PDFOut != "" {
-		ctx.Errorf("-texout and -pdfout are only valid in file mode (omit them with -serve)")
-		os.Exit(2)
-	}
-	if err := internal.Serve(cli.Strict, cli.Timeout, 5*time.Second); err != nil {
+	if err := ctx.Run(); err != nil {
 		slog.Error(
-			"server error",
+			"error running command",
+			"command", ctx.Selected().Name,
 			"error", err,
 		)
 		os.Exit(1)
@@ -3,6 +3,6 @@ module go-serverci
 go 1.23.5
 
 require (
-	github.com/alecthomas/kong v1.12.1 // indirect
-	gopkg.in/yaml.v2 v2.4.0 // indirect
+	github.com/alecthomas/kong v1.12.1
+	gopkg.in/yaml.v3 v3.0.1
 )
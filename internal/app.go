@@ -1,15 +1,79 @@
 package internal
 
-import "time"
+import (
+	"go-serverci/pkg"
+	"time"
+)
 
 const APP_NAME = "go-serverci"
 
 type CLI struct {
-	Serve    bool          `help:"Start HTTP server mode. Mutually exclusive with file-based mode."`
-	YAML     string        `name:"yaml"     help:"Path to input YAML file."`
-	Template string        `name:"template" help:"Path to LaTeX template file (.tex)."`
-	TexOut   string        `name:"texout"   help:"(Optional) Path to output .tex file (file mode only)."`
-	PDFOut   string        `name:"pdfout"   help:"(Optional) Directory for compiled PDF (file mode only)."`
-	Strict   bool          `help:"(Optional) Fail on missing template keys." default:"True"`
+	Render       RenderCmd       `cmd:"" help:"Render a CI document into a PDF using a LaTeX template."`
+	Validate     ValidateCmd     `cmd:"" help:"Validate CI documents without rendering them."`
+	Serve        ServeCmd        `cmd:"" help:"Start the HTTP server."`
+	LintTemplate LintTemplateCmd `cmd:"" name:"lint-template" help:"Check templates against the CI data model without rendering them."`
+	Convert      ConvertCmd      `cmd:"" help:"Convert a CI document between YAML and JSON."`
+	Diff         DiffCmd         `cmd:"" help:"Print the field-level changes between two CI documents."`
+	Schema       SchemaCmd       `cmd:"" help:"Print the JSON Schema of CI documents."`
+}
+
+type RenderCmd struct {
+	YAML     string        `arg:"" name:"yaml" help:"Path to input YAML or JSON file."`
+	Template string        `name:"template" short:"t" required:"" help:"Path to LaTeX template file (.tex)."`
+	TexOut   string        `name:"texout"   help:"(Optional) Path to output .tex file."`
+	TexMap   string        `name:"texmap"   help:"(Optional) Path to output a map from .tex lines to template lines."`
+	YAMLOut  string        `name:"yamlout"  help:"(Optional) Path to output the YAML including appended versions."`
+	Previous string        `name:"previous" help:"(Optional) Previous YAML file, appends a version summarising the changes."`
+	User     string        `name:"user"     help:"(Optional) User recorded in appended versions." env:"USER"`
+	PDFOut   string        `name:"pdfout"   help:"(Optional) Path to output PDF file, \".pdf\" is appended if it has no extension."`
+	Strict   bool          `help:"(Optional) Fail on missing template keys." default:"true" negatable:""`
+	FailOn   pkg.Severity  `name:"fail-on" enum:"error,warning,info" default:"error" help:"(Optional) Lowest validation severity which fails (error, warning or info)."`
+	Policy   string        `name:"policy" help:"(Optional) Policy file with additional validation rules."`
 	Timeout  time.Duration `name:"timeout" help:"(Optional) Timeout for TeX compilation." default:"2m"`
+
+	ShowSensitive bool `name:"show-sensitive" help:"(Optional) Keep the values of sensitive fields like snmp in --texout, --yamlout and compiler errors."`
+}
+
+type ValidateCmd struct {
+	Files  []string     `arg:"" name:"file" help:"CI documents to validate (.yaml, .yml or .json)."`
+	Format string       `name:"format" enum:"text,json" default:"text" help:"(Optional) Report format (text or json)."`
+	FailOn pkg.Severity `name:"fail-on" enum:"error,warning,info" default:"error" help:"(Optional) Lowest severity which fails validation (error, warning or info)."`
+	Policy string       `name:"policy" help:"(Optional) Policy file with additional validation rules."`
+
+	ShowMerged bool `name:"show-merged" help:"(Optional) Print the effective documents after merging the documents they extend, with sensitive fields redacted."`
+}
+
+type ServeCmd struct {
+	Strict       bool          `help:"(Optional) Fail on missing template keys." default:"true" negatable:""`
+	Timeout      time.Duration `name:"timeout"       help:"(Optional) Timeout for TeX compilation." default:"2m"`
+	MaxCompiles  int           `name:"max-compiles"  help:"(Optional) Maximum number of concurrent TeX compilations." default:"4"`
+	CompileQueue int           `name:"compile-queue" help:"(Optional) Maximum number of requests waiting for a compilation before rejecting with 503." default:"16"`
+	TemplateDir  string        `name:"template-dir"  help:"(Optional) Directory of named templates clients can reference instead of uploading one."`
+	AdminToken   string        `name:"admin-token"   help:"(Optional) Bearer token enabling the admin endpoints, e.g. template upload." env:"GO_SERVERCI_ADMIN_TOKEN"`
+	StoreDir     string        `name:"store-dir"     help:"(Optional) Directory to persist CIs in, enables the /cis endpoints."`
+	Policy       string        `name:"policy"        help:"(Optional) Policy file with additional validation rules."`
+	Workers      int           `name:"workers"       help:"(Optional) Number of background render jobs compiled concurrently." default:"2"`
+	JobQueue     int           `name:"job-queue"     help:"(Optional) Maximum number of queued render jobs." default:"100"`
+	JobRetention time.Duration `name:"job-retention" help:"(Optional) How long finished render jobs are kept." default:"1h"`
+}
+
+type LintTemplateCmd struct {
+	Templates []string `arg:"" name:"template" help:"LaTeX template files (.tex) to check."`
+}
+
+type ConvertCmd struct {
+	Input  string `arg:"" name:"input" help:"CI document to convert (.yaml, .yml or .json)."`
+	To     string `name:"to" enum:"yaml,json," default:"" help:"(Optional) Output format, defaults to the opposite of the input (yaml or json)."`
+	Output string `name:"output" short:"o" help:"(Optional) Path to output file, defaults to stdout."`
+
+	ShowSensitive bool `name:"show-sensitive" help:"(Optional) Keep the values of sensitive fields like snmp in the output."`
+}
+
+type DiffCmd struct {
+	Old string `arg:"" name:"old" help:"Previous CI document."`
+	New string `arg:"" name:"new" help:"Current CI document."`
+}
+
+type SchemaCmd struct {
+	Output string `name:"output" short:"o" help:"(Optional) Path to output file, defaults to stdout."`
 }
@@ -0,0 +1,236 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-serverci/pkg"
+	"os"
+	"time"
+)
+
+// validationReport lists the findings of one document.
+type validationReport struct {
+	File     string        `json:"file,omitempty"`
+	Valid    bool          `json:"valid"`
+	Findings []pkg.Finding `json:"findings"`
+	// Merged is the effective document, with --show-merged.
+	Merged *pkg.Root `json:"merged,omitempty"`
+}
+
+// newValidationReport reports all findings, the document is valid if none
+// is as severe as failOn.
+func newValidationReport(findings pkg.MultiError, failOn pkg.Severity) validationReport {
+	return validationReport{
+		Valid:    len(findings.AtLeast(failOn)) == 0,
+		Findings: findings.Findings(),
+	}
+}
+
+// loadPolicyFile loads the policy file at path, or returns nil without path.
+func loadPolicyFile(path string) (*pkg.Policy, error) {
+	if path == "" {
+		return nil, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("policy open error: %w", err)
+	}
+	defer f.Close()
+
+	policy, err := pkg.LoadPolicy(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: policy error: %w", path, err)
+	}
+	return policy, nil
+}
+
+// checkRoot returns the findings of the built-in validation followed by
+// those of the policy, located in file. Values of sensitive fields are
+// redacted from the messages.
+func checkRoot(file string, root *pkg.Root, policy *pkg.Policy) pkg.MultiError {
+	findings := root.Check()
+	if root.CI != nil {
+		findings = append(findings, policy.Check(root.CI)...)
+	}
+	root.Locate(file, findings)
+	root.Secrets().RedactFindings(findings)
+	return findings
+}
+
+// Run validates every document and reports all findings, not just the first.
+// It fails if any document is invalid.
+func (c *ValidateCmd) Run() error {
+	policy, err := loadPolicyFile(c.Policy)
+	if err != nil {
+		return err
+	}
+
+	reports := make([]validationReport, 0, len(c.Files))
+	failed := 0
+	for _, path := range c.Files {
+		var report validationReport
+		if root, err := decodeCIFile(path); err != nil {
+			report = validationReport{Findings: []pkg.Finding{{
+				Code:     pkg.CodeDecode,
+				Message:  errors.Unwrap(err).Error(),
+				Severity: pkg.SeverityError,
+			}}}
+		} else {
+			report = newValidationReport(checkRoot(path, root, policy), c.FailOn)
+			if c.ShowMerged {
+				if report.Merged, err = root.Redacted(); err != nil {
+					return fmt.Errorf("%s: redacting ci: %w", path, err)
+				}
+			}
+		}
+		report.File = path
+		if !report.Valid {
+			failed++
+		}
+		reports = append(reports, report)
+	}
+
+	if c.Format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(reports); err != nil {
+			return err
+		}
+	} else {
+		for _, report := range reports {
+			if report.Merged != nil {
+				fmt.Fprintf(os.Stdout, "# %s\n", report.File)
+				if err := pkg.EncodeYaml(os.Stdout, report.Merged); err != nil {
+					return err
+				}
+			}
+			if len(report.Findings) == 0 {
+				fmt.Fprintf(os.Stdout, "%s: ok\n", report.File)
+			}
+			for _, f := range report.Findings {
+				pos := report.File
+				if f.File != "" {
+					pos = f.File
+				}
+				if f.Line > 0 {
+					pos = fmt.Sprintf("%s:%d:%d", pos, f.Line, f.Column)
+				}
+				if f.Path != "" {
+					fmt.Fprintf(os.Stdout, "%s: %s: %s: %s\n", pos, f.Severity, f.Path, f.Message)
+				} else {
+					fmt.Fprintf(os.Stdout, "%s: %s: %s\n", pos, f.Severity, f.Message)
+				}
+			}
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d documents failed validation", failed, len(c.Files))
+	}
+	return nil
+}
+
+// Run checks the field references of every template.
+func (c *LintTemplateCmd) Run() error {
+	issues := 0
+	for _, path := range c.Templates {
+		f, err := os.Open(path)
+		if err != nil {
+			return fmt.Errorf("template open error: %w", err)
+		}
+		found, err := pkg.LintTempl(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("%s: template parsing error: %w", path, err)
+		}
+		for _, issue := range found {
+			fmt.Fprintf(os.Stdout, "%s:%s\n", path, issue)
+		}
+		issues += len(found)
+	}
+	if issues > 0 {
+		return fmt.Errorf("found %d template issues", issues)
+	}
+	return nil
+}
+
+// Run converts a CI document between YAML and JSON. The document is decoded
+// into the data model, so unknown fields are rejected. Sensitive fields are
+// redacted unless --show-sensitive is given.
+func (c *ConvertCmd) Run() error {
+	root, err := decodeCIFile(c.Input)
+	if err != nil {
+		return err
+	}
+	if !c.ShowSensitive {
+		if root, err = root.Redacted(); err != nil {
+			return fmt.Errorf("redaction error: %w", err)
+		}
+	}
+
+	to := c.To
+	if to == "" {
+		to = "json"
+		if isJSONFile(c.Input) {
+			to = "yaml"
+		}
+	}
+
+	var out bytes.Buffer
+	switch to {
+	case "json":
+		err = pkg.EncodeJson(&out, root)
+	default:
+		err = pkg.EncodeYaml(&out, root)
+	}
+	if err != nil {
+		return fmt.Errorf("%s encode error: %w", to, err)
+	}
+
+	if c.Output == "" {
+		_, err = out.WriteTo(os.Stdout)
+		return err
+	}
+	if err := os.WriteFile(c.Output, out.Bytes(), 0o644); err != nil {
+		return fmt.Errorf("output writing error: %w", err)
+	}
+	return nil
+}
+
+// Run prints the JSON Schema of CI documents.
+func (c *SchemaCmd) Run() error {
+	out, err := json.MarshalIndent(pkg.CISchema(), "", "  ")
+	if err != nil {
+		return fmt.Errorf("schema encode error: %w", err)
+	}
+	out = append(out, '\n')
+
+	if c.Output == "" {
+		_, err = os.Stdout.Write(out)
+		return err
+	}
+	if err := os.WriteFile(c.Output, out, 0o644); err != nil {
+		return fmt.Errorf("output writing error: %w", err)
+	}
+	return nil
+}
+
+// Run starts the HTTP server.
+func (c *ServeCmd) Run() error {
+	return Serve(ServerConfig{
+		Strict:          c.Strict,
+		Timeout:         c.Timeout,
+		ShutdownTimeout: 5 * time.Second,
+		Workers:         c.Workers,
+		JobQueue:        c.JobQueue,
+		JobRetention:    c.JobRetention,
+		MaxCompiles:     c.MaxCompiles,
+		CompileQueue:    c.CompileQueue,
+		TemplateDir:     c.TemplateDir,
+		AdminToken:      c.AdminToken,
+		StoreDir:        c.StoreDir,
+		PolicyFile:      c.Policy,
+	})
+}
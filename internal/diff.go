@@ -0,0 +1,24 @@
+package internal
+
+import (
+	"fmt"
+	"go-serverci/pkg"
+	"os"
+)
+
+// Run prints the field-level changes between two CI documents.
+func (c *DiffCmd) Run() error {
+	oldRoot, err := decodeCIFile(c.Old)
+	if err != nil {
+		return err
+	}
+	newRoot, err := decodeCIFile(c.New)
+	if err != nil {
+		return err
+	}
+
+	for _, change := range pkg.DiffCI(oldRoot.CI, newRoot.CI) {
+		fmt.Fprintln(os.Stdout, change)
+	}
+	return nil
+}
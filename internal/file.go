@@ -1,61 +1,175 @@
 package internal
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"go-serverci/pkg"
+	"log/slog"
 	"os"
+	"path/filepath"
+	"strings"
 	"time"
 )
 
-func RunFileMode(c CLI) error {
-	in, err := os.Open(c.YAML)
+// Run renders a CI document into a PDF.
+func (c *RenderCmd) Run() error {
+	root, err := decodeCIFile(c.YAML)
 	if err != nil {
-		return fmt.Errorf("open yaml error: %w", err)
+		return err
 	}
-	defer in.Close()
 
-	root, err := pkg.DecodeYaml(in)
+	if c.Previous != "" && root.CI != nil {
+		prev, err := decodeCIFile(c.Previous)
+		if err != nil {
+			return err
+		}
+		if v := pkg.AppendVersion(prev.CI, root.CI, c.User, time.Now()); v != nil {
+			slog.Info("appended version", "number", *v.Number, "description", *v.Description)
+		}
+	}
+
+	policy, err := loadPolicyFile(c.Policy)
 	if err != nil {
-		return fmt.Errorf("open yaml decode error: %w", err)
+		return err
+	}
+	findings := checkRoot(c.YAML, root, policy)
+	for _, f := range findings {
+		if !f.Severity.AtLeast(c.FailOn) {
+			slog.Warn("ci validation", "position", f.Position(), "path", f.Path, "severity", f.Severity, "message", f.Err)
+		}
+	}
+	if err := findings.AtLeast(c.FailOn).ToError(); err != nil {
+		return fmt.Errorf("validation error:\n%w", err)
 	}
 
-	if err := root.Validate(); err != nil {
-		return fmt.Errorf("yaml validation error: %w", err)
+	if c.YAMLOut != "" {
+		doc := root
+		if !c.ShowSensitive {
+			if doc, err = root.Redacted(); err != nil {
+				return fmt.Errorf("redaction error: %w", err)
+			}
+		}
+		var out bytes.Buffer
+		if err := pkg.EncodeYaml(&out, doc); err != nil {
+			return fmt.Errorf("yaml encode error: %w", err)
+		}
+		if err := os.WriteFile(c.YAMLOut, out.Bytes(), 0o644); err != nil {
+			return fmt.Errorf("yaml output writing error: %w", err)
+		}
 	}
 
-	tmplReader, err := os.Open(c.Template)
+	tmpl, err := os.ReadFile(c.Template)
 	if err != nil {
 		return fmt.Errorf("template open error: %w", err)
 	}
-	defer tmplReader.Close()
 
-	processedTmplBytes, err := pkg.ParseTempl(tmplReader, *root, c.Strict)
+	secrets := root.Secrets()
+	if c.ShowSensitive {
+		secrets = nil
+	}
+
+	processedTmplBytes, sourceMap, err := pkg.ParseTempl(bytes.NewReader(tmpl), *root, c.Strict)
 	if err != nil {
-		return fmt.Errorf("template parsing error: %w", err)
+		return fmt.Errorf("template parsing error: %s", secrets.Redact(err.Error()))
 	}
+	sourceMap.Name = filepath.Base(c.Template)
 
 	if c.TexOut != "" {
-		if err := os.WriteFile(c.TexOut, processedTmplBytes, 0o644); err != nil {
+		texOut := processedTmplBytes
+		if len(secrets) > 0 {
+			// Render again without the secrets instead of searching them
+			// in the document.
+			redacted, err := root.Redacted()
+			if err != nil {
+				return fmt.Errorf("redacting ci: %w", err)
+			}
+			if texOut, _, err = pkg.ParseTempl(bytes.NewReader(tmpl), *redacted, c.Strict); err != nil {
+				return fmt.Errorf("template parsing error: %s", secrets.Redact(err.Error()))
+			}
+		}
+		if err := os.WriteFile(c.TexOut, texOut, 0o644); err != nil {
 			return fmt.Errorf("tex output writing error: %w", err)
 		}
 	}
 
+	if c.TexMap != "" {
+		f, err := os.Create(c.TexMap)
+		if err != nil {
+			return fmt.Errorf("tex map output error: %w", err)
+		}
+		if _, err := sourceMap.WriteTo(f); err != nil {
+			f.Close()
+			return fmt.Errorf("tex map output writing error: %w", err)
+		}
+		if err := f.Close(); err != nil {
+			return fmt.Errorf("tex map output writing error: %w", err)
+		}
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), c.Timeout)
 	defer cancel()
 
-	pdfFilePath := ""
-	if c.PDFOut != "" {
-		pdfFilePath = c.PDFOut
+	pdfFilePath := c.PDFOut
+	if pdfFilePath != "" {
+		// Keep the path as given, only "report" becomes "report.pdf".
+		if filepath.Ext(pdfFilePath) == "" {
+			pdfFilePath += ".pdf"
+		}
 	} else {
 		timestamp := time.Now().Format("20060102_150405")
-		pdfFilePath = "doc_" + timestamp
+		pdfFilePath = "doc_" + timestamp + ".pdf"
 	}
 
-	err = pkg.CompileTeX(ctx, processedTmplBytes, pdfFilePath)
+	pdf, err := pkg.CompileTeX(ctx, processedTmplBytes)
 	if err != nil {
-		return fmt.Errorf("tex compilation error: %w", err)
+		var ce *pkg.CompileError
+		if errors.As(err, &ce) {
+			sourceMap.Annotate(ce.Diagnostics)
+			secrets.RedactDiagnostics(ce.Diagnostics)
+			for _, d := range ce.Diagnostics {
+				fmt.Fprintln(os.Stderr, d)
+				if d.Context != "" {
+					fmt.Fprintf(os.Stderr, "\t%s\n", d.Context)
+				}
+			}
+			return fmt.Errorf("tex compilation error: %s", secrets.Redact(ce.Err.Error()))
+		}
+		return fmt.Errorf("tex compilation error: %s", secrets.Redact(err.Error()))
+	}
+
+	if dir := filepath.Dir(pdfFilePath); dir != "." {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return fmt.Errorf("creating output directory: %w", err)
+		}
+	}
+	if err := os.WriteFile(pdfFilePath, pdf, 0o644); err != nil {
+		return fmt.Errorf("pdf output writing error: %w", err)
 	}
 
 	return nil
 }
+
+// decodeCIFile decodes a CI document, JSON if the file has a .json extension
+// and YAML otherwise. YAML documents may extend others, and references to
+// environment variables and files are interpolated.
+func decodeCIFile(path string) (*pkg.Root, error) {
+	if !isJSONFile(path) {
+		root, err := pkg.DecodeYamlFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("%s: yaml decode error: %w", path, err)
+		}
+		return root, nil
+	}
+
+	root, err := pkg.DecodeJsonFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("%s: json decode error: %w", path, err)
+	}
+	return root, nil
+}
+
+func isJSONFile(path string) bool {
+	return strings.EqualFold(filepath.Ext(path), ".json")
+}
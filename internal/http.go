@@ -3,121 +3,383 @@ package internal
 import (
 	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"go-serverci/pkg"
+	"io"
 	"log/slog"
+	"math"
 	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
 )
 
-func Serve(strict bool, timeout time.Duration, shutdownTimeout time.Duration) error {
+type errorResponse struct {
+	Error       string           `json:"error"`
+	Diagnostics []pkg.Diagnostic `json:"diagnostics,omitempty"`
+}
 
-	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodPost {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-			return
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("error writing response", "error", err)
+	}
+}
+
+type ServerConfig struct {
+	Strict          bool
+	Timeout         time.Duration
+	ShutdownTimeout time.Duration
+	Workers         int
+	JobQueue        int
+	JobRetention    time.Duration
+	MaxCompiles     int
+	CompileQueue    int
+	TemplateDir     string
+	AdminToken      string
+	StoreDir        string
+	PolicyFile      string
+}
+
+type server struct {
+	cfg       ServerConfig
+	jobs      *jobQueue
+	limiter   *compileLimiter
+	templates *templateRegistry
+	store     *ciStore
+	policy    *pkg.Policy
+	schema    *pkg.Schema
+}
+
+// renderRequest is the decoded input of a render, read completely so that
+// it outlives the HTTP request for asynchronous jobs.
+type renderRequest struct {
+	root         *pkg.Root
+	template     []byte
+	templateName string
+	// warnings are validation findings which don't block rendering.
+	warnings pkg.MultiError
+}
+
+// renderError carries the HTTP status and compiler diagnostics of a failed
+// request or render.
+type renderError struct {
+	status      int
+	err         error
+	diagnostics []pkg.Diagnostic
+	retryAfter  time.Duration
+}
+
+func (e *renderError) Error() string { return e.err.Error() }
+
+func (e *renderError) Unwrap() error { return e.err }
+
+func requestError(status int, format string, args ...any) *renderError {
+	return &renderError{status: status, err: fmt.Errorf(format, args...)}
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	var re *renderError
+	if !errors.As(err, &re) {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if re.retryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(re.retryAfter.Seconds()))))
+	}
+	if len(re.diagnostics) > 0 {
+		writeJSON(w, re.status, errorResponse{Error: re.Error(), Diagnostics: re.diagnostics})
+		return
+	}
+	http.Error(w, re.Error(), re.status)
+}
+
+func (s *server) readRenderRequest(r *http.Request) (*renderRequest, error) {
+	ct := r.Header.Get("Content-Type")
+	if !strings.HasPrefix(ct, "multipart/form-data") {
+		return nil, requestError(http.StatusUnsupportedMediaType, "Content-Type must be multipart/form-data")
+	}
+
+	root, err := readFormRoot(r)
+	if err != nil {
+		return nil, err
+	}
+
+	findings := checkRoot("", root, s.policy)
+	if err := findings.AtLeast(pkg.SeverityError).ToError(); err != nil {
+		return nil, requestError(http.StatusBadRequest, "ci validation error:%v\n", err)
+	}
+
+	tmplFile, tmplHeader, err := r.FormFile("template")
+	if err == http.ErrMissingFile {
+		name := r.FormValue("template")
+		if name == "" {
+			return nil, requestError(http.StatusBadRequest, "Missing values: provide either template file or template name 'template'")
 		}
-		ct := r.Header.Get("Content-Type")
-		if !strings.HasPrefix(ct, "multipart/form-data") {
-			http.Error(w, "Content-Type must be multipart/form-data", http.StatusUnsupportedMediaType)
-			return
+		t, ok := s.templates.get(name)
+		if !ok {
+			return nil, requestError(http.StatusBadRequest, "Unknown template %q", name)
 		}
+		return &renderRequest{root: root, template: t.source, templateName: t.Name + ".tex", warnings: findings}, nil
+	}
+	if err != nil {
+		return nil, requestError(http.StatusBadRequest, "Error reading file: %v", err)
+	}
+	defer tmplFile.Close()
 
-		if err := r.ParseMultipartForm(10 << 20); err != nil {
-			http.Error(w, fmt.Sprintf("Error parsing form: %v", err), http.StatusBadRequest)
-			return
-		}
+	tmpl, err := io.ReadAll(tmplFile)
+	if err != nil {
+		return nil, requestError(http.StatusBadRequest, "Error reading file: %v", err)
+	}
 
-		var (
-			root *pkg.Root
-			err  error
-		)
-
-		if yamlFile, _, yfErr := r.FormFile("ci_yaml"); yfErr == nil {
-			defer yamlFile.Close()
-			root, err = pkg.DecodeYaml(yamlFile)
-			if err != nil {
-				http.Error(w, fmt.Sprintf("Invalid YAML: %v", err), http.StatusBadRequest)
-				return
-			}
-		} else if yfErr != http.ErrMissingFile {
-			http.Error(w, fmt.Sprintf("Error reading YAML file: %v", yfErr), http.StatusBadRequest)
-			return
-		} else {
-			ciJson := r.FormValue("ci")
-			if ciJson == "" {
-				http.Error(w, "Missing values: provide either YAML file 'ci_yaml' or JSON field 'ci'", http.StatusBadRequest)
-				return
-			}
-			root, err = pkg.DecodeJson(bytes.NewReader([]byte(ciJson)))
-			if err != nil {
-				http.Error(w, fmt.Sprintf("Invalid JSON: %v", err), http.StatusBadRequest)
-				return
-			}
-		}
+	return &renderRequest{root: root, template: tmpl, templateName: tmplHeader.Filename, warnings: findings}, nil
+}
 
-		if err := root.Validate(); err != nil {
-			http.Error(w, fmt.Sprintf("ci validation error:%v\n", err), http.StatusBadRequest)
-			return
-		}
+// readFormRoot decodes the CI of a multipart form, either the YAML file
+// 'ci_yaml' or the JSON field 'ci'.
+func readFormRoot(r *http.Request) (*pkg.Root, error) {
+	if err := r.ParseMultipartForm(10 << 20); err != nil {
+		return nil, requestError(http.StatusBadRequest, "Error parsing form: %v", err)
+	}
+
+	var (
+		root *pkg.Root
+		err  error
+	)
 
-		tmplFile, _, err := r.FormFile("template")
+	if yamlFile, _, yfErr := r.FormFile("ci_yaml"); yfErr == nil {
+		defer yamlFile.Close()
+		root, err = pkg.DecodeYaml(yamlFile)
 		if err != nil {
-			http.Error(w, fmt.Sprintf("Error reading file: %v", err), http.StatusBadRequest)
-			return
+			return nil, requestError(http.StatusBadRequest, "Invalid YAML: %v", err)
 		}
-		defer tmplFile.Close()
-
-		processedTmplBytes, err := pkg.ParseTempl(tmplFile, *root, strict)
+	} else if yfErr != http.ErrMissingFile {
+		return nil, requestError(http.StatusBadRequest, "Error reading YAML file: %v", yfErr)
+	} else {
+		ciJson := r.FormValue("ci")
+		if ciJson == "" {
+			return nil, requestError(http.StatusBadRequest, "Missing values: provide either YAML file 'ci_yaml' or JSON field 'ci'")
+		}
+		root, err = pkg.DecodeJson(bytes.NewReader([]byte(ciJson)))
 		if err != nil {
-			http.Error(w, fmt.Sprintf("error parsing template file: %v\n", err), http.StatusBadRequest)
-			return
+			return nil, requestError(http.StatusBadRequest, "Invalid JSON: %v", err)
 		}
+	}
+	return root, nil
+}
 
-		ctx, cancel := context.WithTimeout(context.Background(), timeout)
-		defer cancel()
+func (s *server) render(ctx context.Context, req *renderRequest) ([]byte, error) {
+	return s.renderWith(ctx, req, s.limiter.acquire)
+}
 
-		timestamp := time.Now().Format("20060102_150405")
-		pdfBase := "doc_" + timestamp
+// renderJob renders in a job worker, which waits for a compile slot even if
+// the wait queue of synchronous requests is full.
+func (s *server) renderJob(ctx context.Context, req *renderRequest) ([]byte, error) {
+	return s.renderWith(ctx, req, s.limiter.wait)
+}
 
-		if err := pkg.CompileTeX(ctx, processedTmplBytes, pdfBase); err != nil {
-			http.Error(w, fmt.Sprintf("error compiling tex: %v", err), http.StatusInternalServerError)
-			return
+func (s *server) renderWith(ctx context.Context, req *renderRequest, acquire func(context.Context) (func(), error)) ([]byte, error) {
+	secrets := req.root.Secrets()
+	processedTmplBytes, sourceMap, err := pkg.ParseTempl(bytes.NewReader(req.template), *req.root, s.cfg.Strict)
+	if err != nil {
+		return nil, requestError(http.StatusBadRequest, "error parsing template file: %s", secrets.Redact(err.Error()))
+	}
+	sourceMap.Name = req.templateName
+
+	release, err := acquire(ctx)
+	if errors.Is(err, errBusy) {
+		return nil, &renderError{status: http.StatusServiceUnavailable, err: err, retryAfter: s.limiter.retryAfter()}
+	}
+	if err != nil {
+		return nil, requestError(http.StatusServiceUnavailable, "waiting for compilation: %v", err)
+	}
+	defer release()
+
+	ctx, cancel := context.WithTimeout(ctx, s.cfg.Timeout)
+	defer cancel()
+
+	pdf, err := pkg.CompileTeX(ctx, processedTmplBytes)
+	var ce *pkg.CompileError
+	if errors.As(err, &ce) {
+		sourceMap.Annotate(ce.Diagnostics)
+		secrets.RedactDiagnostics(ce.Diagnostics)
+		return nil, &renderError{
+			status:      http.StatusUnprocessableEntity,
+			err:         fmt.Errorf("error compiling tex: %s", secrets.Redact(ce.Err.Error())),
+			diagnostics: ce.Diagnostics,
 		}
+	}
+	if err != nil {
+		return nil, requestError(http.StatusInternalServerError, "error compiling tex: %s", secrets.Redact(err.Error()))
+	}
+	return pdf, nil
+}
 
-		pdfPath := pdfBase + ".pdf"
-		f, err := os.Open(pdfPath)
-		if err != nil {
-			http.Error(w, fmt.Sprintf("error opening compiled pdf: %v", err), http.StatusInternalServerError)
-			_ = os.Remove(pdfPath)
+// setWarningHeaders adds an X-CI-Warning header per validation finding
+// which did not block the request.
+func setWarningHeaders(w http.ResponseWriter, warnings pkg.MultiError) {
+	for _, ve := range warnings {
+		w.Header().Add("X-CI-Warning", ve.Error())
+	}
+}
+
+func writePDF(w http.ResponseWriter, r *http.Request, name string, pdf []byte) {
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, name))
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(pdf))
+}
+
+func (s *server) handleProcess(w http.ResponseWriter, r *http.Request) {
+	req, err := s.readRenderRequest(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	setWarningHeaders(w, req.warnings)
+	pdf, err := s.render(r.Context(), req)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	timestamp := time.Now().Format("20060102_150405")
+	writePDF(w, r, "doc_"+timestamp+".pdf", pdf)
+}
+
+// handleValidate validates a CI without rendering it. The CI is read from a
+// multipart form like for /process, or from a YAML or JSON request body. The
+// CI is invalid if a finding is at least as severe as ?fail-on=.
+func (s *server) handleValidate(w http.ResponseWriter, r *http.Request) {
+	failOn := pkg.SeverityError
+	if v := r.URL.Query().Get("fail-on"); v != "" {
+		failOn = pkg.Severity(v)
+		switch failOn {
+		case pkg.SeverityError, pkg.SeverityWarning, pkg.SeverityInfo:
+		default:
+			http.Error(w, fmt.Sprintf("invalid fail-on %q (use error, warning or info)", v), http.StatusBadRequest)
 			return
 		}
-		defer func() {
-			f.Close()
-			_ = os.Remove(pdfPath)
-		}()
+	}
 
-		fi, err := f.Stat()
-		if err != nil {
-			http.Error(w, fmt.Sprintf("error stating compiled pdf: %v", err), http.StatusInternalServerError)
-			return
+	var (
+		root *pkg.Root
+		err  error
+	)
+	ct := r.Header.Get("Content-Type")
+	switch {
+	case strings.HasPrefix(ct, "multipart/form-data"):
+		root, err = readFormRoot(r)
+	case isYAMLContentType(ct):
+		root, err = pkg.DecodeYaml(io.LimitReader(r.Body, 10<<20))
+	default:
+		root, err = pkg.DecodeJson(io.LimitReader(r.Body, 10<<20))
+	}
+	if err != nil {
+		var re *renderError
+		if !errors.As(err, &re) {
+			err = requestError(http.StatusBadRequest, "Invalid document: %v", err)
 		}
+		writeError(w, err)
+		return
+	}
+
+	report := newValidationReport(checkRoot("", root, s.policy), failOn)
+	status := http.StatusOK
+	if !report.Valid {
+		status = http.StatusUnprocessableEntity
+	}
+	writeJSON(w, status, report)
+}
+
+// handleSchema serves the JSON Schema of CI documents.
+func (s *server) handleSchema(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/schema+json")
+	if err := json.NewEncoder(w).Encode(s.schema); err != nil {
+		slog.Error("error writing response", "error", err)
+	}
+}
+
+type serverStatus struct {
+	Compiles limiterStats `json:"compiles"`
+	Jobs     jobStats     `json:"jobs"`
+}
 
-		w.Header().Set("Content-Type", "application/pdf")
-		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, pdfBase+".pdf"))
-		w.Header().Set("Content-Length", fmt.Sprintf("%d", fi.Size()))
-		http.ServeContent(w, r, fi.Name(), fi.ModTime(), f)
+func (s *server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, serverStatus{
+		Compiles: s.limiter.stats(),
+		Jobs:     s.jobs.stats(),
 	})
+}
+
+func Serve(cfg ServerConfig) error {
+	if cfg.Workers < 1 {
+		return fmt.Errorf("workers must be at least 1, got %d", cfg.Workers)
+	}
+	if cfg.JobQueue < 1 {
+		return fmt.Errorf("job queue must hold at least 1 job, got %d", cfg.JobQueue)
+	}
+
+	templates, err := loadTemplateRegistry(cfg.TemplateDir)
+	if err != nil {
+		return err
+	}
+
+	s := &server{
+		cfg:       cfg,
+		jobs:      newJobQueue(cfg.JobQueue, cfg.JobRetention),
+		limiter:   newCompileLimiter(cfg.MaxCompiles, cfg.CompileQueue),
+		templates: templates,
+		schema:    pkg.CISchema(),
+	}
+	if cfg.StoreDir != "" {
+		if s.store, err = openCIStore(cfg.StoreDir); err != nil {
+			return err
+		}
+	}
+	if s.policy, err = loadPolicyFile(cfg.PolicyFile); err != nil {
+		return err
+	}
+
+	jobCtx, stopJobs := context.WithCancel(context.Background())
+	defer stopJobs()
+	s.jobs.start(jobCtx, cfg.Workers, s.renderJob)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /{$}", s.handleProcess)
+	mux.HandleFunc("POST /process", s.handleProcess)
+	mux.HandleFunc("POST /validate", s.handleValidate)
+	mux.HandleFunc("POST /jobs", s.handleCreateJob)
+	mux.HandleFunc("GET /jobs/{id}", s.handleGetJob)
+	mux.HandleFunc("GET /jobs/{id}/pdf", s.handleGetJobPDF)
+	mux.HandleFunc("GET /schema", s.handleSchema)
+	mux.HandleFunc("GET /status", s.handleStatus)
+	mux.HandleFunc("GET /templates", s.handleListTemplates)
+	mux.HandleFunc("GET /templates/{name}", s.handleGetTemplate)
+	mux.HandleFunc("PUT /templates/{name}", s.handlePutTemplate)
+	if s.store != nil {
+		mux.HandleFunc("GET /cis", s.handleListCIs)
+		mux.HandleFunc("GET /cis/{name}", s.handleGetCI)
+		mux.HandleFunc("PUT /cis/{name}", s.handlePutCI)
+		mux.HandleFunc("DELETE /cis/{name}", s.handleDeleteCI)
+		mux.HandleFunc("GET /cis/{name}/pdf", s.handleRenderCI)
+		mux.HandleFunc("GET /cis/{name}/revisions", s.handleListRevisions)
+		mux.HandleFunc("GET /cis/{name}/revisions/{rev}", s.handleGetRevision)
+		mux.HandleFunc("GET /cis/{name}/diff", s.handleDiffCI)
+	}
 
 	server := &http.Server{
-		Handler:      handler,
-		ReadTimeout:  10 * time.Second,
-		WriteTimeout: 10 * time.Second,
+		Handler:     mux,
+		ReadTimeout: 10 * time.Second,
+		// Synchronous renders respond only after compilation finished.
+		WriteTimeout: cfg.Timeout + 10*time.Second,
 	}
 
 	stop := make(chan os.Signal, 1)
@@ -136,8 +398,8 @@ func Serve(strict bool, timeout time.Duration, shutdownTimeout time.Duration) er
 
 	<-stop
 
-	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
-	slog.Info("shutting down server gracefully", "shutdownTimeout", shutdownTimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	slog.Info("shutting down server gracefully", "shutdownTimeout", cfg.ShutdownTimeout)
 
 	defer cancel()
 
@@ -0,0 +1,225 @@
+package internal
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"go-serverci/pkg"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type JobStatus string
+
+const (
+	JobQueued    JobStatus = "queued"
+	JobCompiling JobStatus = "compiling"
+	JobDone      JobStatus = "done"
+	JobFailed    JobStatus = "failed"
+)
+
+var errQueueFull = errors.New("job queue is full")
+
+type job struct {
+	ID          string           `json:"id"`
+	Status      JobStatus        `json:"status"`
+	CreatedAt   time.Time        `json:"createdAt"`
+	StartedAt   *time.Time       `json:"startedAt,omitempty"`
+	FinishedAt  *time.Time       `json:"finishedAt,omitempty"`
+	Error       string           `json:"error,omitempty"`
+	Diagnostics []pkg.Diagnostic `json:"diagnostics,omitempty"`
+	Warnings    []pkg.Finding    `json:"warnings,omitempty"`
+	PDF         string           `json:"pdf,omitempty"`
+
+	req *renderRequest
+	pdf []byte
+}
+
+type renderFunc func(ctx context.Context, req *renderRequest) ([]byte, error)
+
+// jobQueue runs renders in the background on a bounded pool of workers and
+// keeps finished jobs for the configured retention.
+type jobQueue struct {
+	mu        sync.Mutex
+	jobs      map[string]*job
+	pending   chan *job
+	retention time.Duration
+}
+
+func newJobQueue(size int, retention time.Duration) *jobQueue {
+	return &jobQueue{
+		jobs:      map[string]*job{},
+		pending:   make(chan *job, size),
+		retention: retention,
+	}
+}
+
+func (q *jobQueue) start(ctx context.Context, workers int, render renderFunc) {
+	for i := 0; i < workers; i++ {
+		go q.work(ctx, render)
+	}
+	go q.expire(ctx)
+}
+
+func (q *jobQueue) submit(req *renderRequest) (job, error) {
+	id, err := newJobID()
+	if err != nil {
+		return job{}, err
+	}
+	j := &job{ID: id, Status: JobQueued, CreatedAt: time.Now(), req: req}
+	if len(req.warnings) > 0 {
+		j.Warnings = req.warnings.Findings()
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	select {
+	case q.pending <- j:
+	default:
+		return job{}, errQueueFull
+	}
+	q.jobs[id] = j
+	return *j, nil
+}
+
+type jobStats struct {
+	Queued    int `json:"queued"`
+	MaxQueued int `json:"maxQueued"`
+	Retained  int `json:"retained"`
+}
+
+func (q *jobQueue) stats() jobStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return jobStats{
+		Queued:    len(q.pending),
+		MaxQueued: cap(q.pending),
+		Retained:  len(q.jobs),
+	}
+}
+
+// get returns a snapshot of the job, safe to use without holding the lock.
+func (q *jobQueue) get(id string) (job, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	j, ok := q.jobs[id]
+	if !ok {
+		return job{}, false
+	}
+	return *j, true
+}
+
+func (q *jobQueue) work(ctx context.Context, render renderFunc) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case j := <-q.pending:
+			q.run(ctx, j, render)
+		}
+	}
+}
+
+func (q *jobQueue) run(ctx context.Context, j *job, render renderFunc) {
+	q.mu.Lock()
+	started := time.Now()
+	j.Status, j.StartedAt = JobCompiling, &started
+	req := j.req
+	q.mu.Unlock()
+
+	pdf, err := render(ctx, req)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	finished := time.Now()
+	j.FinishedAt, j.req = &finished, nil
+	if err != nil {
+		// render redacts what it reports, this covers any other error.
+		j.Status, j.Error = JobFailed, req.root.Secrets().Redact(err.Error())
+		var re *renderError
+		if errors.As(err, &re) {
+			j.Diagnostics = re.diagnostics
+		}
+		slog.Info("job failed", "id", j.ID, "error", j.Error)
+		return
+	}
+	j.Status, j.pdf, j.PDF = JobDone, pdf, "/jobs/"+j.ID+"/pdf"
+}
+
+func (q *jobQueue) expire(ctx context.Context) {
+	interval := min(q.retention, time.Minute)
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			q.mu.Lock()
+			for id, j := range q.jobs {
+				if j.FinishedAt != nil && now.Sub(*j.FinishedAt) > q.retention {
+					delete(q.jobs, id)
+				}
+			}
+			q.mu.Unlock()
+		}
+	}
+}
+
+func newJobID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generating job id: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func (s *server) handleCreateJob(w http.ResponseWriter, r *http.Request) {
+	req, err := s.readRenderRequest(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	j, err := s.jobs.submit(req)
+	if errors.Is(err, errQueueFull) {
+		writeError(w, &renderError{status: http.StatusServiceUnavailable, err: err, retryAfter: s.limiter.retryAfter()})
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Location", "/jobs/"+j.ID)
+	writeJSON(w, http.StatusAccepted, j)
+}
+
+func (s *server) handleGetJob(w http.ResponseWriter, r *http.Request) {
+	j, ok := s.jobs.get(r.PathValue("id"))
+	if !ok {
+		http.Error(w, "job not found", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, j)
+}
+
+func (s *server) handleGetJobPDF(w http.ResponseWriter, r *http.Request) {
+	j, ok := s.jobs.get(r.PathValue("id"))
+	if !ok {
+		http.Error(w, "job not found", http.StatusNotFound)
+		return
+	}
+	if j.Status != JobDone {
+		writeJSON(w, http.StatusConflict, j)
+		return
+	}
+	writePDF(w, r, "doc_"+j.ID+".pdf", j.pdf)
+}
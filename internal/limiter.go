@@ -0,0 +1,123 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync"
+	"time"
+)
+
+var errBusy = errors.New("too many compilations in progress, try again later")
+
+// compileLimiter bounds the number of concurrently running TeX compilations
+// and the number of callers waiting for one of the slots.
+type compileLimiter struct {
+	slots   chan struct{}
+	maxWait int
+
+	mu      sync.Mutex
+	waiting int
+	avg     time.Duration
+}
+
+type limiterStats struct {
+	Compiling   int `json:"compiling"`
+	Waiting     int `json:"waiting"`
+	MaxCompiles int `json:"maxCompiles"`
+	MaxWaiting  int `json:"maxWaiting"`
+}
+
+func newCompileLimiter(maxCompiles, maxWait int) *compileLimiter {
+	return &compileLimiter{
+		slots:   make(chan struct{}, max(maxCompiles, 1)),
+		maxWait: maxWait,
+	}
+}
+
+// acquire waits for a free slot and fails with errBusy right away if the
+// wait queue is full.
+func (l *compileLimiter) acquire(ctx context.Context) (func(), error) {
+	return l.enter(ctx, true)
+}
+
+// wait waits for a free slot regardless of the wait queue bound. It is used
+// by the job workers, which are bounded by their own queue.
+func (l *compileLimiter) wait(ctx context.Context) (func(), error) {
+	return l.enter(ctx, false)
+}
+
+func (l *compileLimiter) enter(ctx context.Context, bounded bool) (func(), error) {
+	select {
+	case l.slots <- struct{}{}:
+		return l.releaser(), nil
+	default:
+	}
+
+	l.mu.Lock()
+	if bounded && l.waiting >= l.maxWait {
+		l.mu.Unlock()
+		return nil, errBusy
+	}
+	l.waiting++
+	l.mu.Unlock()
+
+	defer func() {
+		l.mu.Lock()
+		l.waiting--
+		l.mu.Unlock()
+	}()
+
+	select {
+	case l.slots <- struct{}{}:
+		return l.releaser(), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (l *compileLimiter) releaser() func() {
+	start := time.Now()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			<-l.slots
+			l.observe(time.Since(start))
+		})
+	}
+}
+
+// observe keeps a moving average of compile durations to derive Retry-After.
+func (l *compileLimiter) observe(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.avg == 0 {
+		l.avg = d
+		return
+	}
+	l.avg = (4*l.avg + d) / 5
+}
+
+// retryAfter estimates how long it takes until the current backlog drained.
+func (l *compileLimiter) retryAfter() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	avg := l.avg
+	if avg == 0 {
+		avg = 5 * time.Second
+	}
+	backlog := float64(l.waiting+len(l.slots)) / float64(cap(l.slots))
+	d := time.Duration(math.Ceil(backlog * float64(avg)))
+	return min(max(d, time.Second), 2*time.Minute)
+}
+
+func (l *compileLimiter) stats() limiterStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return limiterStats{
+		Compiling:   len(l.slots),
+		Waiting:     l.waiting,
+		MaxCompiles: cap(l.slots),
+		MaxWaiting:  l.maxWait,
+	}
+}
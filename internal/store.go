@@ -0,0 +1,524 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-serverci/pkg"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	errCINotFound       = errors.New("ci not found")
+	errRevisionNotFound = errors.New("revision not found")
+)
+
+type CIEntry struct {
+	Name      string    `json:"name"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// Revision describes one immutable write of a stored CI.
+type Revision struct {
+	Number    int       `json:"revision"`
+	Author    string    `json:"author"`
+	Timestamp time.Time `json:"timestamp"`
+	Deleted   bool      `json:"deleted,omitempty"`
+}
+
+// ciStore persists pkg.Root documents as "<name>.yaml" files in dir. Every
+// write is additionally kept as revision in ".history/<name>/", with the
+// document in "<revision>.yaml" and its Revision in "<revision>.json".
+type ciStore struct {
+	dir string
+	mu  sync.RWMutex
+}
+
+func openCIStore(dir string) (*ciStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("creating ci store directory: %w", err)
+	}
+	return &ciStore{dir: dir}, nil
+}
+
+func (st *ciStore) path(name string) string {
+	return filepath.Join(st.dir, name+".yaml")
+}
+
+func (st *ciStore) get(name string) (*pkg.Root, error) {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+	return st.read(name)
+}
+
+// read decodes the document stored under name. The caller holds st.mu.
+func (st *ciStore) read(name string) (*pkg.Root, error) {
+	f, err := os.Open(st.path(name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, errCINotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return pkg.DecodeYaml(f)
+}
+
+// update stores the document fn returns for the one stored under name, nil
+// if there is none, as a new revision and reports whether it replaced a
+// document. The store is locked meanwhile, so that fn sees the latest
+// revision. If fn fails, nothing is stored.
+func (st *ciStore) update(name, author string, fn func(prev *pkg.Root) (*pkg.Root, error)) (Revision, bool, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	prev, err := st.read(name)
+	if err != nil && !errors.Is(err, errCINotFound) {
+		return Revision{}, false, err
+	}
+	root, err := fn(prev)
+	if err != nil {
+		return Revision{}, false, err
+	}
+
+	var buf bytes.Buffer
+	if err := pkg.EncodeYaml(&buf, root); err != nil {
+		return Revision{}, false, fmt.Errorf("encoding ci: %w", err)
+	}
+	b := buf.Bytes()
+	rev, err := st.addRevision(name, author, b)
+	if err != nil {
+		return Revision{}, false, err
+	}
+	if err := writeFileAtomic(st.path(name), b); err != nil {
+		return Revision{}, false, err
+	}
+	return rev, prev != nil, nil
+}
+
+func (st *ciStore) delete(name, author string) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if _, err := os.Stat(st.path(name)); errors.Is(err, os.ErrNotExist) {
+		return errCINotFound
+	}
+	if _, err := st.addRevision(name, author, nil); err != nil {
+		return err
+	}
+	return os.Remove(st.path(name))
+}
+
+func (st *ciStore) historyDir(name string) string {
+	return filepath.Join(st.dir, ".history", name)
+}
+
+// addRevision records doc as the next revision of name, a nil doc marks the
+// deletion of the CI. The caller must hold the write lock.
+func (st *ciStore) addRevision(name, author string, doc []byte) (Revision, error) {
+	revs, err := st.readRevisions(name)
+	if err != nil {
+		return Revision{}, err
+	}
+	rev := Revision{Number: 1, Author: author, Timestamp: time.Now(), Deleted: doc == nil}
+	if len(revs) > 0 {
+		rev.Number = revs[len(revs)-1].Number + 1
+	}
+
+	dir := st.historyDir(name)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return Revision{}, fmt.Errorf("creating history directory: %w", err)
+	}
+	base := filepath.Join(dir, fmt.Sprintf("%06d", rev.Number))
+	if doc != nil {
+		if err := writeFileAtomic(base+".yaml", doc); err != nil {
+			return Revision{}, err
+		}
+	}
+	meta, err := json.Marshal(rev)
+	if err != nil {
+		return Revision{}, fmt.Errorf("encoding revision: %w", err)
+	}
+	if err := writeFileAtomic(base+".json", meta); err != nil {
+		return Revision{}, err
+	}
+	return rev, nil
+}
+
+func (st *ciStore) readRevisions(name string) ([]Revision, error) {
+	entries, err := os.ReadDir(st.historyDir(name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var revs []Revision
+	for _, e := range entries {
+		if e.IsDir() || filepath.Ext(e.Name()) != ".json" || strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		b, err := os.ReadFile(filepath.Join(st.historyDir(name), e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		var rev Revision
+		if err := json.Unmarshal(b, &rev); err != nil {
+			return nil, fmt.Errorf("decoding revision %s: %w", e.Name(), err)
+		}
+		revs = append(revs, rev)
+	}
+	sort.Slice(revs, func(i, j int) bool { return revs[i].Number < revs[j].Number })
+	return revs, nil
+}
+
+func (st *ciStore) revisions(name string) ([]Revision, error) {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+	revs, err := st.readRevisions(name)
+	if err != nil {
+		return nil, err
+	}
+	if len(revs) == 0 {
+		return nil, errCINotFound
+	}
+	return revs, nil
+}
+
+func (st *ciStore) revision(name string, n int) (*pkg.Root, error) {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+	f, err := os.Open(filepath.Join(st.historyDir(name), fmt.Sprintf("%06d.yaml", n)))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, errRevisionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return pkg.DecodeYaml(f)
+}
+
+// list returns all stored documents whose name or content contains query,
+// ignoring case.
+func (st *ciStore) list(query string) ([]CIEntry, error) {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+	entries, err := os.ReadDir(st.dir)
+	if err != nil {
+		return nil, err
+	}
+	query = strings.ToLower(query)
+
+	cis := []CIEntry{}
+	for _, e := range entries {
+		name, ok := strings.CutSuffix(e.Name(), ".yaml")
+		if e.IsDir() || !ok {
+			continue
+		}
+		if query != "" && !strings.Contains(strings.ToLower(name), query) {
+			b, err := os.ReadFile(st.path(name))
+			if err != nil {
+				return nil, err
+			}
+			if !bytes.Contains(bytes.ToLower(b), []byte(query)) {
+				continue
+			}
+		}
+		fi, err := e.Info()
+		if err != nil {
+			return nil, err
+		}
+		cis = append(cis, CIEntry{Name: name, UpdatedAt: fi.ModTime()})
+	}
+	sort.Slice(cis, func(i, j int) bool { return cis[i].Name < cis[j].Name })
+	return cis, nil
+}
+
+func writeFileAtomic(path string, b []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return fmt.Errorf("writing %s: %w", path, err)
+	}
+	tmp := f.Name()
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		_ = os.Remove(tmp)
+		return fmt.Errorf("writing %s: %w", path, err)
+	}
+	if err := f.Close(); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("writing %s: %w", path, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("writing %s: %w", path, err)
+	}
+	return nil
+}
+
+func isYAMLContentType(ct string) bool {
+	return strings.Contains(ct, "yaml")
+}
+
+func (s *server) ciName(w http.ResponseWriter, r *http.Request) (string, bool) {
+	name := r.PathValue("name")
+	if !reResourceName.MatchString(name) {
+		http.Error(w, fmt.Sprintf("invalid ci name %q", name), http.StatusBadRequest)
+		return "", false
+	}
+	return name, true
+}
+
+func (s *server) writeStoreError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errCINotFound) || errors.Is(err, errRevisionNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
+
+func (s *server) handleListCIs(w http.ResponseWriter, r *http.Request) {
+	cis, err := s.store.list(r.URL.Query().Get("q"))
+	if err != nil {
+		s.writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, cis)
+}
+
+func (s *server) handleGetCI(w http.ResponseWriter, r *http.Request) {
+	name, ok := s.ciName(w, r)
+	if !ok {
+		return
+	}
+	root, err := s.store.get(name)
+	if err != nil {
+		s.writeStoreError(w, err)
+		return
+	}
+	writeCI(w, r, root)
+}
+
+func writeCI(w http.ResponseWriter, r *http.Request, root *pkg.Root) {
+	if isYAMLContentType(r.Header.Get("Accept")) {
+		var buf bytes.Buffer
+		if err := pkg.EncodeYaml(&buf, root); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/yaml")
+		_, _ = buf.WriteTo(w)
+		return
+	}
+	writeJSON(w, http.StatusOK, root)
+}
+
+func (s *server) handlePutCI(w http.ResponseWriter, r *http.Request) {
+	name, ok := s.ciName(w, r)
+	if !ok {
+		return
+	}
+
+	body := io.LimitReader(r.Body, 10<<20)
+	var (
+		root *pkg.Root
+		err  error
+	)
+	if isYAMLContentType(r.Header.Get("Content-Type")) {
+		root, err = pkg.DecodeYaml(body)
+	} else {
+		root, err = pkg.DecodeJson(body)
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid document: %v", err), http.StatusBadRequest)
+		return
+	}
+	if root.CI == nil {
+		http.Error(w, "Invalid document: missing 'ci'", http.StatusBadRequest)
+		return
+	}
+	autoVersion, _ := strconv.ParseBool(r.URL.Query().Get("auto-version"))
+	var (
+		findings pkg.MultiError
+		invalid  error
+	)
+	rev, replaced, err := s.store.update(name, author(r), func(prev *pkg.Root) (*pkg.Root, error) {
+		if autoVersion {
+			var prevCI *pkg.CI
+			if prev != nil {
+				prevCI = prev.CI
+			}
+			pkg.AppendVersion(prevCI, root.CI, author(r), time.Now())
+		}
+		findings = checkRoot("", root, s.policy)
+		invalid = findings.AtLeast(pkg.SeverityError).ToError()
+		return root, invalid
+	})
+	if invalid != nil {
+		http.Error(w, fmt.Sprintf("ci validation error:%v\n", invalid), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		s.writeStoreError(w, err)
+		return
+	}
+	setWarningHeaders(w, findings)
+	w.Header().Set("X-Revision", strconv.Itoa(rev.Number))
+	status := http.StatusCreated
+	if replaced {
+		status = http.StatusOK
+	}
+	w.Header().Set("Location", "/cis/"+name)
+	writeJSON(w, status, root)
+}
+
+func (s *server) handleDeleteCI(w http.ResponseWriter, r *http.Request) {
+	name, ok := s.ciName(w, r)
+	if !ok {
+		return
+	}
+	if err := s.store.delete(name, author(r)); err != nil {
+		s.writeStoreError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *server) handleRenderCI(w http.ResponseWriter, r *http.Request) {
+	name, ok := s.ciName(w, r)
+	if !ok {
+		return
+	}
+	tmplName := r.URL.Query().Get("template")
+	t, ok := s.templates.get(tmplName)
+	if !ok {
+		http.Error(w, fmt.Sprintf("Unknown template %q", tmplName), http.StatusBadRequest)
+		return
+	}
+	root, err := s.store.get(name)
+	if err != nil {
+		s.writeStoreError(w, err)
+		return
+	}
+
+	// The policy may have changed since the CI was stored.
+	warnings := checkRoot("", root, s.policy)
+	if err := warnings.AtLeast(pkg.SeverityError).ToError(); err != nil {
+		http.Error(w, fmt.Sprintf("ci validation error:%v\n", err), http.StatusBadRequest)
+		return
+	}
+	setWarningHeaders(w, warnings)
+	pdf, err := s.render(r.Context(), &renderRequest{root: root, template: t.source, templateName: t.Name + ".tex", warnings: warnings})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writePDF(w, r, name+".pdf", pdf)
+}
+
+// author identifies who changed a stored CI.
+func author(r *http.Request) string {
+	if a := strings.TrimSpace(r.Header.Get("X-Author")); a != "" {
+		return a
+	}
+	return "unknown"
+}
+
+func (s *server) handleListRevisions(w http.ResponseWriter, r *http.Request) {
+	name, ok := s.ciName(w, r)
+	if !ok {
+		return
+	}
+	revs, err := s.store.revisions(name)
+	if err != nil {
+		s.writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, revs)
+}
+
+func (s *server) handleGetRevision(w http.ResponseWriter, r *http.Request) {
+	name, ok := s.ciName(w, r)
+	if !ok {
+		return
+	}
+	n, err := strconv.Atoi(r.PathValue("rev"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid revision %q", r.PathValue("rev")), http.StatusBadRequest)
+		return
+	}
+	root, err := s.store.revision(name, n)
+	if err != nil {
+		s.writeStoreError(w, err)
+		return
+	}
+	writeCI(w, r, root)
+}
+
+// handleDiffCI compares the revisions given by the "from" and "to" query
+// parameters, which default to the latest revision and its predecessor.
+func (s *server) handleDiffCI(w http.ResponseWriter, r *http.Request) {
+	name, ok := s.ciName(w, r)
+	if !ok {
+		return
+	}
+	revs, err := s.store.revisions(name)
+	if err != nil {
+		s.writeStoreError(w, err)
+		return
+	}
+
+	to := revs[len(revs)-1].Number
+	if v := r.URL.Query().Get("to"); v != "" {
+		if to, err = strconv.Atoi(v); err != nil {
+			http.Error(w, fmt.Sprintf("invalid revision %q", v), http.StatusBadRequest)
+			return
+		}
+	}
+	from := to - 1
+	if v := r.URL.Query().Get("from"); v != "" {
+		if from, err = strconv.Atoi(v); err != nil {
+			http.Error(w, fmt.Sprintf("invalid revision %q", v), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Revision 0 and deleted revisions compare as an empty CI.
+	load := func(n int) (*pkg.CI, error) {
+		if n == 0 {
+			return nil, nil
+		}
+		root, err := s.store.revision(name, n)
+		if errors.Is(err, errRevisionNotFound) && n > 0 && n <= revs[len(revs)-1].Number {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		return root.CI, nil
+	}
+	oldCI, err := load(from)
+	if err != nil {
+		s.writeStoreError(w, err)
+		return
+	}
+	newCI, err := load(to)
+	if err != nil {
+		s.writeStoreError(w, err)
+		return
+	}
+
+	changes := pkg.DiffCI(oldCI, newCI)
+	if changes == nil {
+		changes = []pkg.Change{}
+	}
+	writeJSON(w, http.StatusOK, changes)
+}
@@ -0,0 +1,125 @@
+package internal
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func newStoreServer(t *testing.T) (*server, http.Handler) {
+	t.Helper()
+	st, err := openCIStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &server{store: st}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /cis", s.handleListCIs)
+	mux.HandleFunc("GET /cis/{name}", s.handleGetCI)
+	mux.HandleFunc("PUT /cis/{name}", s.handlePutCI)
+	mux.HandleFunc("DELETE /cis/{name}", s.handleDeleteCI)
+	mux.HandleFunc("GET /cis/{name}/revisions", s.handleListRevisions)
+	mux.HandleFunc("GET /cis/{name}/revisions/{rev}", s.handleGetRevision)
+	mux.HandleFunc("GET /cis/{name}/diff", s.handleDiffCI)
+	return s, mux
+}
+
+func putCI(h http.Handler, name, query, doc string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPut, "/cis/"+name+query, strings.NewReader(doc))
+	r.Header.Set("Content-Type", "application/yaml")
+	r.Header.Set("X-Author", "test")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestPutCIAutoVersionConcurrent(t *testing.T) {
+	s, h := newStoreServer(t)
+	const n = 8
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			doc := fmt.Sprintf("ci:\n  configuration:\n    ram: %d\n", i+1)
+			if w := putCI(h, "web", "?auto-version=true", doc); w.Code >= 300 {
+				t.Errorf("PUT %d: %d %s", i, w.Code, w.Body)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// Every revision appends the version following the one of the previous.
+	for rev := 1; rev <= n; rev++ {
+		root, err := s.store.revision("web", rev)
+		if err != nil {
+			t.Fatalf("revision %d: %v", rev, err)
+		}
+		versions := root.CI.Versions
+		if len(versions) == 0 {
+			t.Fatalf("revision %d has no version", rev)
+		}
+		want := fmt.Sprintf("1.%d", rev-1)
+		if got := *versions[len(versions)-1].Number; got != want {
+			t.Errorf("revision %d appended version %s, want %s", rev, got, want)
+		}
+	}
+}
+
+func TestStoreRevisions(t *testing.T) {
+	_, h := newStoreServer(t)
+	steps := []struct {
+		method, path, body string
+		status             int
+		// want are substrings of the response body, in order.
+		want []string
+	}{
+		{"GET", "/cis/web", "", http.StatusNotFound, nil},
+		{"GET", "/cis/web/revisions", "", http.StatusNotFound, nil},
+		{"PUT", "/cis/web", "ci:\n  configuration:\n    ram: 4\n", http.StatusCreated, []string{`"ram":4`}},
+		{"PUT", "/cis/web", "ci:\n  configuration:\n    ram: 8\n", http.StatusOK, []string{`"ram":8`}},
+		{"PUT", "/cis/web", "ci:\n  configuration:\n    ram: -1\n", http.StatusBadRequest, []string{"ci.configuration.ram"}},
+		{"PUT", "/cis/db", "ci:\n  configuration:\n    name: postgres\n", http.StatusCreated, nil},
+		{"PUT", "/cis/-web", "ci: {}\n", http.StatusBadRequest, nil},
+		{"GET", "/cis", "", http.StatusOK, []string{`"name":"db"`, `"name":"web"`}},
+		{"GET", "/cis?q=POSTGRES", "", http.StatusOK, []string{`"name":"db"`}},
+		{"GET", "/cis/web", "", http.StatusOK, []string{`"ram":8`}},
+		{"GET", "/cis/web/revisions", "", http.StatusOK, []string{`"revision":1`, `"author":"test"`, `"revision":2`}},
+		{"GET", "/cis/web/revisions/1", "", http.StatusOK, []string{`"ram":4`}},
+		{"GET", "/cis/web/revisions/3", "", http.StatusNotFound, nil},
+		{"GET", "/cis/web/revisions/x", "", http.StatusBadRequest, nil},
+		{"GET", "/cis/web/diff", "", http.StatusOK, []string{`"path":"ci.configuration.ram"`, `"kind":"changed"`, `"old":"4"`, `"new":"8"`}},
+		{"GET", "/cis/web/diff?from=0&to=1", "", http.StatusOK, []string{`"path":"ci.configuration"`, `"kind":"added"`}},
+		{"DELETE", "/cis/web", "", http.StatusNoContent, nil},
+		{"GET", "/cis/web", "", http.StatusNotFound, nil},
+		{"DELETE", "/cis/web", "", http.StatusNotFound, nil},
+		{"GET", "/cis/web/revisions", "", http.StatusOK, []string{`"revision":3`, `"deleted":true`}},
+		{"GET", "/cis/web/revisions/2", "", http.StatusOK, []string{`"ram":8`}},
+		{"GET", "/cis/web/diff", "", http.StatusOK, []string{`"path":"ci.configuration"`, `"kind":"removed"`}},
+		// A recreated CI continues its history.
+		{"PUT", "/cis/web", "ci:\n  configuration:\n    ram: 16\n", http.StatusCreated, nil},
+		{"GET", "/cis/web/revisions", "", http.StatusOK, []string{`"revision":4`}},
+		{"GET", "/cis", "", http.StatusOK, []string{`"name":"db"`, `"name":"web"`}},
+	}
+	for _, step := range steps {
+		r := httptest.NewRequest(step.method, step.path, strings.NewReader(step.body))
+		r.Header.Set("Content-Type", "application/yaml")
+		r.Header.Set("X-Author", "test")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != step.status {
+			t.Fatalf("%s %s: status %d, want %d: %s", step.method, step.path, w.Code, step.status, w.Body)
+		}
+		body := w.Body.String()
+		for _, want := range step.want {
+			i := strings.Index(body, want)
+			if i < 0 {
+				t.Fatalf("%s %s: %q not in %s", step.method, step.path, want, w.Body)
+			}
+			body = body[i+len(want):]
+		}
+	}
+}
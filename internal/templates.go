@@ -0,0 +1,205 @@
+package internal
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+var reResourceName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+type TemplateMeta struct {
+	Name          string    `yaml:"-" json:"name"`
+	Description   string    `yaml:"description,omitempty" json:"description,omitempty"`
+	SchemaVersion string    `yaml:"schema-version,omitempty" json:"schemaVersion,omitempty"`
+	UpdatedAt     time.Time `yaml:"-" json:"updatedAt"`
+}
+
+type storedTemplate struct {
+	TemplateMeta
+	source []byte
+}
+
+// templateRegistry holds named templates which clients can reference
+// instead of uploading a template with every request. Templates are read
+// from "<name>.tex" files in dir with optional "<name>.yaml" metadata.
+type templateRegistry struct {
+	dir string
+
+	mu        sync.RWMutex
+	templates map[string]*storedTemplate
+}
+
+func loadTemplateRegistry(dir string) (*templateRegistry, error) {
+	reg := &templateRegistry{dir: dir, templates: map[string]*storedTemplate{}}
+	if dir == "" {
+		return reg, nil
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("reading template directory: %w", err)
+	}
+	for _, e := range entries {
+		if e.IsDir() || filepath.Ext(e.Name()) != ".tex" {
+			continue
+		}
+		name := strings.TrimSuffix(e.Name(), ".tex")
+		if !reResourceName.MatchString(name) {
+			slog.Warn("skipping template with invalid name", "file", e.Name())
+			continue
+		}
+		t, err := reg.read(name)
+		if err != nil {
+			return nil, err
+		}
+		reg.templates[name] = t
+	}
+	slog.Info("loaded templates", "dir", dir, "count", len(reg.templates))
+	return reg, nil
+}
+
+func (reg *templateRegistry) read(name string) (*storedTemplate, error) {
+	texPath := filepath.Join(reg.dir, name+".tex")
+	source, err := os.ReadFile(texPath)
+	if err != nil {
+		return nil, fmt.Errorf("reading template %q: %w", name, err)
+	}
+	fi, err := os.Stat(texPath)
+	if err != nil {
+		return nil, fmt.Errorf("reading template %q: %w", name, err)
+	}
+
+	t := &storedTemplate{source: source}
+	metaBytes, err := os.ReadFile(filepath.Join(reg.dir, name+".yaml"))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("reading template metadata %q: %w", name, err)
+	}
+	if err := yaml.Unmarshal(metaBytes, &t.TemplateMeta); err != nil {
+		return nil, fmt.Errorf("decoding template metadata %q: %w", name, err)
+	}
+	t.Name, t.UpdatedAt = name, fi.ModTime()
+	return t, nil
+}
+
+func (reg *templateRegistry) get(name string) (*storedTemplate, bool) {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+	t, ok := reg.templates[name]
+	return t, ok
+}
+
+func (reg *templateRegistry) list() []TemplateMeta {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+	metas := make([]TemplateMeta, 0, len(reg.templates))
+	for _, t := range reg.templates {
+		metas = append(metas, t.TemplateMeta)
+	}
+	sort.Slice(metas, func(i, j int) bool { return metas[i].Name < metas[j].Name })
+	return metas
+}
+
+// put stores a template, persisting it to dir when the registry has one.
+func (reg *templateRegistry) put(meta TemplateMeta, source []byte) error {
+	if !reResourceName.MatchString(meta.Name) {
+		return fmt.Errorf("invalid template name %q", meta.Name)
+	}
+	meta.UpdatedAt = time.Now()
+
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	if reg.dir != "" {
+		metaBytes, err := yaml.Marshal(meta)
+		if err != nil {
+			return fmt.Errorf("encoding template metadata: %w", err)
+		}
+		if err := os.WriteFile(filepath.Join(reg.dir, meta.Name+".yaml"), metaBytes, 0o644); err != nil {
+			return fmt.Errorf("writing template metadata: %w", err)
+		}
+		if err := os.WriteFile(filepath.Join(reg.dir, meta.Name+".tex"), source, 0o644); err != nil {
+			return fmt.Errorf("writing template: %w", err)
+		}
+	}
+	reg.templates[meta.Name] = &storedTemplate{TemplateMeta: meta, source: source}
+	return nil
+}
+
+func (s *server) handleListTemplates(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.templates.list())
+}
+
+func (s *server) handleGetTemplate(w http.ResponseWriter, r *http.Request) {
+	t, ok := s.templates.get(r.PathValue("name"))
+	if !ok {
+		http.Error(w, "template not found", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, t.TemplateMeta)
+}
+
+func (s *server) handlePutTemplate(w http.ResponseWriter, r *http.Request) {
+	if !s.authorizeAdmin(w, r) {
+		return
+	}
+	if err := r.ParseMultipartForm(10 << 20); err != nil {
+		http.Error(w, fmt.Sprintf("Error parsing form: %v", err), http.StatusBadRequest)
+		return
+	}
+	tmplFile, _, err := r.FormFile("template")
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error reading file: %v", err), http.StatusBadRequest)
+		return
+	}
+	defer tmplFile.Close()
+	source, err := io.ReadAll(tmplFile)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error reading file: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	meta := TemplateMeta{
+		Name:          r.PathValue("name"),
+		Description:   r.FormValue("description"),
+		SchemaVersion: r.FormValue("schema-version"),
+	}
+	if !reResourceName.MatchString(meta.Name) {
+		http.Error(w, fmt.Sprintf("invalid template name %q", meta.Name), http.StatusBadRequest)
+		return
+	}
+	if err := s.templates.put(meta, source); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	t, _ := s.templates.get(meta.Name)
+	writeJSON(w, http.StatusOK, t.TemplateMeta)
+}
+
+// authorizeAdmin checks the bearer token of admin endpoints, which are
+// disabled unless an admin token is configured.
+func (s *server) authorizeAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if s.cfg.AdminToken == "" {
+		http.Error(w, "admin endpoints are disabled", http.StatusForbidden)
+		return false
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.cfg.AdminToken)) != 1 {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,263 @@
+package pkg
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// layer is a YAML document which may extend others.
+type layer struct {
+	Extends []string `yaml:"extends"`
+	Root    `yaml:",inline"`
+}
+
+// DecodeYamlFile decodes the YAML document at path. If it lists other
+// documents under extends, relative to its own directory, these are merged
+// in order and the document is laid over the result:
+//   - mappings are merged key by key,
+//   - lists of entries with a name, like interfaces or accounts, are merged
+//     entry by entry, entries with a new name are appended,
+//   - any other value, including other lists, replaces the one beneath,
+//     except empty values which leave it unchanged.
+//
+// Before merging, ${NAME} and ${file:PATH} references in the values of each
+// document are replaced by the environment variable NAME and the content of
+// the file at PATH, relative to the document.
+func DecodeYamlFile(path string) (*Root, error) {
+	c := &composer{files: map[*yaml.Node]string{}, loading: map[string]bool{}}
+	n, err := c.load(path)
+	if err != nil {
+		return nil, err
+	}
+	root := &Root{source: n, files: c.files}
+	if n != nil {
+		if err := n.Decode(root); err != nil {
+			return nil, err
+		}
+	}
+	return root, nil
+}
+
+type composer struct {
+	files   map[*yaml.Node]string
+	loading map[string]bool
+}
+
+// load returns the merged document at path.
+func (c *composer) load(path string) (*yaml.Node, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	if c.loading[abs] {
+		return nil, fmt.Errorf("%s extends itself", path)
+	}
+	c.loading[abs] = true
+	defer delete(c.loading, abs)
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		return nil, err
+	}
+	// Check the keys of each file on its own, the values only once
+	// interpolated and merged.
+	if err := checkFields(&doc, reflect.TypeOf(layer{})); err != nil {
+		return nil, err
+	}
+	if err := interpolateNode(&doc, filepath.Dir(path)); err != nil {
+		return nil, err
+	}
+	var extends []string
+	if len(doc.Content) > 0 {
+		if _, ext := mappingEntry(doc.Content[0], "extends"); ext != nil {
+			if err := ext.Decode(&extends); err != nil {
+				return nil, fmt.Errorf("extends: %w", err)
+			}
+		}
+	}
+
+	var n *yaml.Node
+	for _, ext := range extends {
+		if !filepath.IsAbs(ext) {
+			ext = filepath.Join(filepath.Dir(path), ext)
+		}
+		base, err := c.load(ext)
+		if err != nil {
+			return nil, fmt.Errorf("extends %s: %w", ext, err)
+		}
+		n = c.merge(n, base)
+	}
+	if len(doc.Content) > 0 {
+		own := doc.Content[0]
+		if key, _ := mappingEntry(own, "extends"); key != nil {
+			own = c.without(own, "extends")
+		}
+		c.mark(own, path)
+		n = c.merge(n, own)
+	}
+	return n, nil
+}
+
+// checkFields rejects unknown and repeated keys of n, which is decoded into
+// the type t, like strict decoding does.
+func checkFields(n *yaml.Node, t reflect.Type) error {
+	if n.Kind == yaml.DocumentNode {
+		for _, child := range n.Content {
+			if err := checkFields(child, t); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch {
+	case t.Kind() == reflect.Struct && n.Kind == yaml.MappingNode:
+		fields := map[string]reflect.Type{}
+		structFields(t, fields)
+		seen := map[string]bool{}
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			key := n.Content[i]
+			ft, ok := fields[key.Value]
+			if !ok {
+				return fmt.Errorf("line %d: field %s not found in type %s", key.Line, key.Value, t)
+			}
+			if seen[key.Value] {
+				return fmt.Errorf("line %d: mapping key %q already defined", key.Line, key.Value)
+			}
+			seen[key.Value] = true
+			if err := checkFields(n.Content[i+1], ft); err != nil {
+				return err
+			}
+		}
+	case t.Kind() == reflect.Slice && n.Kind == yaml.SequenceNode:
+		for _, item := range n.Content {
+			if err := checkFields(item, t.Elem()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// structFields adds the YAML keys of t and their types to fields, including
+// those of inlined structs.
+func structFields(t reflect.Type, fields map[string]reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if strings.Contains(f.Tag.Get("yaml"), ",inline") {
+			structFields(f.Type, fields)
+			continue
+		}
+		if name := yamlFieldName(f); name != "" {
+			fields[name] = f.Type
+		}
+	}
+}
+
+// mark records path as the file of n and its children.
+func (c *composer) mark(n *yaml.Node, path string) {
+	c.files[n] = path
+	for _, child := range n.Content {
+		c.mark(child, path)
+	}
+}
+
+// merge lays over onto base. Nodes of base are copied, not modified.
+// Merged nodes are located at over, the most specific document.
+func (c *composer) merge(base, over *yaml.Node) *yaml.Node {
+	switch {
+	case over == nil || isNullNode(over):
+		return base
+	case base == nil || isNullNode(base) || base.Kind != over.Kind:
+		return over
+	}
+
+	switch over.Kind {
+	case yaml.MappingNode:
+		out := c.copyAt(base, over)
+		for i := 0; i+1 < len(over.Content); i += 2 {
+			key, value := over.Content[i], over.Content[i+1]
+			if j := mappingIndex(out, key.Value); j >= 0 {
+				out.Content[j] = key
+				out.Content[j+1] = c.merge(out.Content[j+1], value)
+			} else {
+				out.Content = append(out.Content, key, value)
+			}
+		}
+		return out
+	case yaml.SequenceNode:
+		if !namedEntries(base) || !namedEntries(over) {
+			return over
+		}
+		out := c.copyAt(base, over)
+	entries:
+		for _, entry := range over.Content {
+			_, name := mappingEntry(entry, "name")
+			for j, b := range out.Content {
+				if _, bn := mappingEntry(b, "name"); bn.Value == name.Value {
+					out.Content[j] = c.merge(b, entry)
+					continue entries
+				}
+			}
+			out.Content = append(out.Content, entry)
+		}
+		return out
+	}
+	return over
+}
+
+// without returns a copy of the mapping n without key.
+func (c *composer) without(n *yaml.Node, key string) *yaml.Node {
+	out := c.copyAt(n, n)
+	out.Content = out.Content[:0]
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value != key {
+			out.Content = append(out.Content, n.Content[i], n.Content[i+1])
+		}
+	}
+	return out
+}
+
+// copyAt returns a shallow copy of n located at the position of at.
+func (c *composer) copyAt(n, at *yaml.Node) *yaml.Node {
+	out := *n
+	out.Content = append([]*yaml.Node(nil), n.Content...)
+	out.Line, out.Column = at.Line, at.Column
+	c.files[&out] = c.files[at]
+	return &out
+}
+
+// namedEntries reports whether every entry of the list n is a mapping with
+// a name.
+func namedEntries(n *yaml.Node) bool {
+	for _, entry := range n.Content {
+		if _, name := mappingEntry(entry, "name"); name == nil || name.Kind != yaml.ScalarNode || isNullNode(name) {
+			return false
+		}
+	}
+	return true
+}
+
+func mappingIndex(n *yaml.Node, key string) int {
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == key {
+			return i
+		}
+	}
+	return -1
+}
+
+func isNullNode(n *yaml.Node) bool {
+	return n.Kind == yaml.ScalarNode && n.ShortTag() == "!!null"
+}
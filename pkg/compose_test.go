@@ -0,0 +1,180 @@
+package pkg
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeFiles writes files, keyed by their path relative to dir.
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestDecodeYamlFileExtends(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		// want is the merged document of main.yaml.
+		want string
+	}{
+		{
+			name: "mappings merge key by key",
+			files: map[string]string{
+				"base.yaml": "ci:\n  author-company: Base\n  configuration:\n    ram: 4\n    cpu: 2\n",
+				"main.yaml": "extends: [base.yaml]\nci:\n  configuration:\n    ram: 8\n",
+			},
+			want: "ci:\n  author-company: Base\n  configuration:\n    ram: 8\n    cpu: 2\n",
+		},
+		{
+			name: "named list entries merge by name",
+			files: map[string]string{
+				"base.yaml": "ci:\n  interfaces:\n  - name: eth0\n    vlan: 10\n    zone: LAN\n  - name: eth1\n    vlan: 20\n",
+				"main.yaml": "extends: [base.yaml]\nci:\n  interfaces:\n  - name: eth1\n    vlan: 30\n  - name: eth2\n    vlan: 40\n",
+			},
+			want: "ci:\n  interfaces:\n    - name: eth0\n      zone: LAN\n      vlan: 10\n    - name: eth1\n      vlan: 30\n    - name: eth2\n      vlan: 40\n",
+		},
+		{
+			name: "other lists are replaced",
+			files: map[string]string{
+				"base.yaml": "ci:\n  configuration:\n    ntp: [1.1.1.1, 2.2.2.2]\n",
+				"main.yaml": "extends: [base.yaml]\nci:\n  configuration:\n    ntp: [3.3.3.3]\n",
+			},
+			want: "ci:\n  configuration:\n    ntp:\n      - 3.3.3.3\n",
+		},
+		{
+			name: "empty values keep the base",
+			files: map[string]string{
+				"base.yaml": "ci:\n  author-company: Base\n",
+				"main.yaml": "extends: [base.yaml]\nci:\n  author-company:\n",
+			},
+			want: "ci:\n  author-company: Base\n",
+		},
+		{
+			name: "later bases override earlier ones",
+			files: map[string]string{
+				"a.yaml":    "ci:\n  author-company: A\n  classification: Internal\n",
+				"b.yaml":    "ci:\n  author-company: B\n",
+				"main.yaml": "extends: [a.yaml, b.yaml]\n",
+			},
+			want: "ci:\n  author-company: B\n  classification: Internal\n",
+		},
+		{
+			name: "nested and relative to the extending file",
+			files: map[string]string{
+				"common/base.yaml": "ci:\n  author-company: Base\n",
+				"common/team.yaml": "extends: [base.yaml]\nci:\n  author-department: Team\n",
+				"main.yaml":        "extends: [common/team.yaml]\nci:\n  classification: Internal\n",
+			},
+			want: "ci:\n  author-company: Base\n  author-department: Team\n  classification: Internal\n",
+		},
+	}
+	for _, tt := range tests {
+		dir := t.TempDir()
+		writeFiles(t, dir, tt.files)
+		root, err := DecodeYamlFile(filepath.Join(dir, "main.yaml"))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		var out bytes.Buffer
+		if err := EncodeYaml(&out, root); err != nil {
+			t.Fatal(err)
+		}
+		if out.String() != tt.want {
+			t.Errorf("%s: got\n%s\nwant\n%s", tt.name, out.String(), tt.want)
+		}
+	}
+}
+
+func TestDecodeYamlFileExtendsErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		err   string
+	}{
+		{
+			name:  "self",
+			files: map[string]string{"main.yaml": "extends: [main.yaml]\n"},
+			err:   "extends itself",
+		},
+		{
+			name: "cycle",
+			files: map[string]string{
+				"a.yaml":    "extends: [main.yaml]\n",
+				"main.yaml": "extends: [a.yaml]\n",
+			},
+			err: "extends itself",
+		},
+		{
+			name:  "missing base",
+			files: map[string]string{"main.yaml": "extends: [none.yaml]\n"},
+			err:   "none.yaml",
+		},
+		{
+			name:  "extends no list",
+			files: map[string]string{"main.yaml": "extends: {a: b}\n"},
+			err:   "extends:",
+		},
+		{
+			name: "unknown field in base",
+			files: map[string]string{
+				"base.yaml": "ci:\n  colour: red\n",
+				"main.yaml": "extends: [base.yaml]\n",
+			},
+			err: "field colour not found",
+		},
+		{
+			name:  "duplicate key",
+			files: map[string]string{"main.yaml": "ci:\n  author-company: A\n  author-company: B\n"},
+			err:   `"author-company" already defined`,
+		},
+	}
+	for _, tt := range tests {
+		dir := t.TempDir()
+		writeFiles(t, dir, tt.files)
+		_, err := DecodeYamlFile(filepath.Join(dir, "main.yaml"))
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: error = %v, want %q", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestLocateExtends(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"base.yaml": "ci:\n  interfaces:\n  - name: eth0\n    vlan: 5000\n",
+		"main.yaml": "extends: [base.yaml]\nci:\n  configuration:\n    ram: -1\n",
+	})
+	main := filepath.Join(dir, "main.yaml")
+	root, err := DecodeYamlFile(main)
+	if err != nil {
+		t.Fatal(err)
+	}
+	findings := root.Check().AtLeast(SeverityError)
+	root.Locate(main, findings)
+	want := map[string]string{
+		"ci.interfaces[0].vlan": filepath.Join(dir, "base.yaml") + ":4:5",
+		"ci.configuration.ram":  main + ":4:5",
+	}
+	for _, f := range findings {
+		if pos, ok := want[f.Path]; ok && f.Position() != pos {
+			t.Errorf("%s located at %s, want %s", f.Path, f.Position(), pos)
+		}
+		delete(want, f.Path)
+	}
+	for path := range want {
+		t.Errorf("no finding for %s", path)
+	}
+}
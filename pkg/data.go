@@ -1,111 +1,182 @@
 package pkg
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
 	"strconv"
 	"strings"
 
-	"gopkg.in/yaml.v2"
+	"gopkg.in/yaml.v3"
 )
 
+// Root is a CI document. Fields have the same names in YAML and JSON, so
+// documents convert between both without loss.
 type Root struct {
-	CI *CI `yaml:"ci"`
+	CI *CI `yaml:"ci,omitempty" json:"ci,omitempty"`
+
+	// source is the YAML document the root was decoded from and files the
+	// file each of its nodes came from, used to locate findings.
+	source *yaml.Node
+	files  map[*yaml.Node]string
 }
 
 type CI struct {
-	AuthorCompany      *string              `yaml:"author-company" json:"authorCompany"`
-	AuthorDepartment   *string              `yaml:"author-department" json:"authorDepartment"`
-	Classification     *string              `yaml:"classification" json:"classification"`
-	Versions           []*Version           `yaml:"versions" json:"versions"`
-	AuditVersions      []*AuditVersion      `yaml:"audit-versions" json:"auditVersions"`
-	ReleaseVersions    []*ReleaseVersion    `yaml:"release-versions" json:"releaseVersions"`
-	Requirements       []*Requirement       `yaml:"requirements" json:"requirements"`
-	SurroundingSystems []*SurroundingSystem `yaml:"surrounding-systems" json:"surroundingSystems"`
-	Description        *Description         `yaml:"description" json:"description"`
-	Configuration      *Configuration       `yaml:"configuration" json:"configuration"`
-	Interfaces         []*Interface         `yaml:"interfaces" json:"interfaces"`
-	Accounts           []*Account           `yaml:"accounts" json:"accounts"`
+	AuthorCompany      *string              `yaml:"author-company,omitempty" json:"author-company,omitempty"`
+	AuthorDepartment   *string              `yaml:"author-department,omitempty" json:"author-department,omitempty"`
+	Classification     *string              `yaml:"classification,omitempty" json:"classification,omitempty"`
+	Versions           []*Version           `yaml:"versions,omitempty" json:"versions,omitempty"`
+	AuditVersions      []*AuditVersion      `yaml:"audit-versions,omitempty" json:"audit-versions,omitempty"`
+	ReleaseVersions    []*ReleaseVersion    `yaml:"release-versions,omitempty" json:"release-versions,omitempty"`
+	Requirements       []*Requirement       `yaml:"requirements,omitempty" json:"requirements,omitempty"`
+	SurroundingSystems []*SurroundingSystem `yaml:"surrounding-systems,omitempty" json:"surrounding-systems,omitempty"`
+	Description        *Description         `yaml:"description,omitempty" json:"description,omitempty"`
+	Configuration      *Configuration       `yaml:"configuration,omitempty" json:"configuration,omitempty"`
+	Interfaces         []*Interface         `yaml:"interfaces,omitempty" json:"interfaces,omitempty"`
+	Storage            []*Volume            `yaml:"storage,omitempty" json:"storage,omitempty"`
+	Software           []*Software          `yaml:"software,omitempty" json:"software,omitempty"`
+	Services           []*Service           `yaml:"services,omitempty" json:"services,omitempty"`
+	Accounts           []*Account           `yaml:"accounts,omitempty" json:"accounts,omitempty"`
 }
 
 type Account struct {
-	Type  *string `yaml:"type" json:"type"`
-	Name  *string `yaml:"name" json:"name"`
-	Usage *string `yaml:"usage" json:"usage"`
+	Type  *string `yaml:"type,omitempty" json:"type,omitempty"`
+	Name  *string `yaml:"name,omitempty" json:"name,omitempty"`
+	Usage *string `yaml:"usage,omitempty" json:"usage,omitempty"`
 }
 
 type Version struct {
-	Number      *string `yaml:"number" json:"name"`
-	Date        *string `yaml:"date" json:"date"`
-	User        *string `yaml:"user" json:"user"`
-	Description *string `yaml:"description" json:"description"`
+	Number      *string `yaml:"number,omitempty" json:"number,omitempty"`
+	Date        *string `yaml:"date,omitempty" json:"date,omitempty"`
+	User        *string `yaml:"user,omitempty" json:"user,omitempty"`
+	Description *string `yaml:"description,omitempty" json:"description,omitempty"`
 }
 
 type AuditVersion struct {
-	Number    *string `yaml:"number"`
-	Date      *string `yaml:"date"`
-	Authority *string `yaml:"authority"`
-	Remarks   *string `yaml:"remarks"`
+	Number    *string `yaml:"number,omitempty" json:"number,omitempty"`
+	Date      *string `yaml:"date,omitempty" json:"date,omitempty"`
+	Authority *string `yaml:"authority,omitempty" json:"authority,omitempty"`
+	Remarks   *string `yaml:"remarks,omitempty" json:"remarks,omitempty"`
 }
 
 type ReleaseVersion struct {
-	Number    *string `yaml:"number" json:"number"`
-	Date      *string `yaml:"date" json:"date"`
-	Authority *string `yaml:"authority" json:"authority"`
-	Remarks   *string `yaml:"remarks" json:"remarks"`
+	Number    *string `yaml:"number,omitempty" json:"number,omitempty"`
+	Date      *string `yaml:"date,omitempty" json:"date,omitempty"`
+	Authority *string `yaml:"authority,omitempty" json:"authority,omitempty"`
+	Remarks   *string `yaml:"remarks,omitempty" json:"remarks,omitempty"`
 }
 
 type Requirement struct {
-	Type *string `yaml:"type" json:"type"`
-	Name *string `yaml:"name" json:"name"`
+	Type *string `yaml:"type,omitempty" json:"type,omitempty"`
+	Name *string `yaml:"name,omitempty" json:"name,omitempty"`
 }
 
 type SurroundingSystem struct {
-	Type        *string `yaml:"type" json:"type"`
-	Name        *string `yaml:"name" json:"name"`
-	Address     *string `yaml:"address" json:"address"`
-	Description *string `yaml:"description" json:"description"`
+	Type        *string `yaml:"type,omitempty" json:"type,omitempty"`
+	Name        *string `yaml:"name,omitempty" json:"name,omitempty"`
+	Address     *string `yaml:"address,omitempty" json:"address,omitempty"`
+	Description *string `yaml:"description,omitempty" json:"description,omitempty"`
 }
 
 type Description struct {
-	ServiceCode *string `yaml:"service-code" json:"serviceCode"`
-	Customer    *string `yaml:"customer" json:"customer"`
-	Descr       *string `yaml:"description" json:"description"`
-	Supplier    *string `yaml:"supplier" json:"supplier"`
-	DisasterLvl *int    `yaml:"disaster-lvl" json:"disasterLvl"`
+	ServiceCode *string `yaml:"service-code,omitempty" json:"service-code,omitempty"`
+	Customer    *string `yaml:"customer,omitempty" json:"customer,omitempty"`
+	Descr       *string `yaml:"description,omitempty" json:"description,omitempty"`
+	Supplier    *string `yaml:"supplier,omitempty" json:"supplier,omitempty"`
+	DisasterLvl *int    `yaml:"disaster-lvl,omitempty" json:"disaster-lvl,omitempty"`
 }
 
 type Configuration struct {
-	Name   *string   `yaml:"name" json:"name"`
-	FQDN   *string   `yaml:"fqdn" json:"fqdn"`
-	OS     *string   `yaml:"os" json:"os"`
-	RAM    *int      `yaml:"ram" json:"ram"`
-	CPU    *int      `yaml:"cpu" json:"cpu"`
-	Domain *string   `yaml:"domain" json:"domain"`
-	NTP    []*string `yaml:"ntp" json:"ntp"`
-	SNMP   *string   `yaml:"snmp" json:"snmp"`
+	Name   *string   `yaml:"name,omitempty" json:"name,omitempty"`
+	FQDN   *string   `yaml:"fqdn,omitempty" json:"fqdn,omitempty"`
+	OS     *string   `yaml:"os,omitempty" json:"os,omitempty"`
+	RAM    *int      `yaml:"ram,omitempty" json:"ram,omitempty"`
+	CPU    *int      `yaml:"cpu,omitempty" json:"cpu,omitempty"`
+	Domain *string   `yaml:"domain,omitempty" json:"domain,omitempty"`
+	NTP    []*string `yaml:"ntp,omitempty" json:"ntp,omitempty"`
+	SNMP   *string   `yaml:"snmp,omitempty" json:"snmp,omitempty" sensitive:"true"`
 }
 
 type Interface struct {
-	Name   *string   `yaml:"name" json:"name"`
-	Zone   *string   `yaml:"zone" json:"zone"`
-	VLAN   *int      `yaml:"vlan" json:"vlan"`
-	DHCP   *bool     `yaml:"dhcp" json:"dhcp"`
-	IP     *string   `yaml:"ip" json:"ip"`
-	Subnet *string   `yaml:"subnet" json:"subnet"`
-	DNS    []*string `yaml:"dns" json:"dns"`
+	Name      *string   `yaml:"name,omitempty" json:"name,omitempty"`
+	Zone      *string   `yaml:"zone,omitempty" json:"zone,omitempty"`
+	VLAN      *int      `yaml:"vlan,omitempty" json:"vlan,omitempty"`
+	DHCP      *bool     `yaml:"dhcp,omitempty" json:"dhcp,omitempty"`
+	IP        *string   `yaml:"ip,omitempty" json:"ip,omitempty"`
+	Subnet    *string   `yaml:"subnet,omitempty" json:"subnet,omitempty"`
+	Gateway   *string   `yaml:"gateway,omitempty" json:"gateway,omitempty"`
+	Addresses []*string `yaml:"addresses,omitempty" json:"addresses,omitempty"`
+	IPv6Mode  *string   `yaml:"ipv6-mode,omitempty" json:"ipv6-mode,omitempty"`
+	Gateway6  *string   `yaml:"gateway6,omitempty" json:"gateway6,omitempty"`
+	DNS       []*string `yaml:"dns,omitempty" json:"dns,omitempty"`
 }
 
-func (r *Root) Validate() error {
+// Volume is a disk or volume of the server. Size is in GB, Mount is a mount
+// point like /var/lib/pgsql or a drive letter like D:.
+type Volume struct {
+	Name       *string `yaml:"name,omitempty" json:"name,omitempty"`
+	Size       *int    `yaml:"size,omitempty" json:"size,omitempty"`
+	Type       *string `yaml:"type,omitempty" json:"type,omitempty"`
+	Mount      *string `yaml:"mount,omitempty" json:"mount,omitempty"`
+	Filesystem *string `yaml:"filesystem,omitempty" json:"filesystem,omitempty"`
+	RAID       *string `yaml:"raid,omitempty" json:"raid,omitempty"`
+	Datastore  *string `yaml:"datastore,omitempty" json:"datastore,omitempty"`
+	Encrypted  *bool   `yaml:"encrypted,omitempty" json:"encrypted,omitempty"`
+}
+
+// Software is an installed product. License refers to the license, e.g. a
+// contract or key ID, not the key itself.
+type Software struct {
+	Product     *string `yaml:"product,omitempty" json:"product,omitempty"`
+	Vendor      *string `yaml:"vendor,omitempty" json:"vendor,omitempty"`
+	Version     *string `yaml:"version,omitempty" json:"version,omitempty"`
+	License     *string `yaml:"license,omitempty" json:"license,omitempty"`
+	InstallPath *string `yaml:"install-path,omitempty" json:"install-path,omitempty"`
+}
+
+// Service is a network service of the server. Interface is the name of the
+// interface or the address it listens on, empty if it listens on all.
+type Service struct {
+	Name      *string `yaml:"name,omitempty" json:"name,omitempty"`
+	Port      *int    `yaml:"port,omitempty" json:"port,omitempty"`
+	Protocol  *string `yaml:"protocol,omitempty" json:"protocol,omitempty"`
+	Interface *string `yaml:"interface,omitempty" json:"interface,omitempty"`
+	StartMode *string `yaml:"start-mode,omitempty" json:"start-mode,omitempty"`
+}
+
+const (
+	ProtocolTCP  = "tcp"
+	ProtocolUDP  = "udp"
+	ProtocolSCTP = "sctp"
+)
+
+const (
+	IPv6ModeStatic = "static"
+	IPv6ModeSLAAC  = "slaac"
+	IPv6ModeDHCPv6 = "dhcpv6"
+)
+
+// Check returns every finding, including warnings which do not block
+// rendering.
+func (r *Root) Check() MultiError {
 	if r == nil || r.CI == nil {
 		return nil
 	}
-	return r.CI.Validate()
+	return r.CI.Check()
 }
 
-func (c *CI) Validate() error {
+// Validate returns the findings with error severity.
+func (r *Root) Validate() error {
+	return r.Check().AtLeast(SeverityError).ToError()
+}
+
+func (c *CI) Check() MultiError {
 	var me MultiError
 
 	for i, v := range c.Versions {
@@ -132,6 +203,7 @@ func (c *CI) Validate() error {
 			me.add("", err)
 		}
 	}
+	me.add("", validateHistory(c))
 	for i, req := range c.Requirements {
 		if req == nil {
 			continue
@@ -153,11 +225,17 @@ func (c *CI) Validate() error {
 			me.add("", err)
 		}
 	}
+	if c.Description == nil || c.Description.DisasterLvl == nil {
+		me.warn("ci.description.disaster_lvl", errorf(CodeDisasterLvlMissing, "disaster-lvl missing"))
+	}
 	if c.Configuration != nil {
 		if err := c.Configuration.Validate("ci.configuration"); err != nil {
 			me.add("", err)
 		}
 	}
+	if c.Configuration == nil || !hasValue(c.Configuration.NTP) {
+		me.warn("ci.configuration.ntp", errorf(CodeNTPMissing, "no NTP servers configured"))
+	}
 	for i, in := range c.Interfaces {
 		if in == nil {
 			continue
@@ -166,7 +244,26 @@ func (c *CI) Validate() error {
 			me.add("", err)
 		}
 	}
-	return me.ToError()
+	me.add("", validateInterfaceSet("ci.interfaces", c.Interfaces))
+	for i, v := range c.Storage {
+		if v == nil {
+			continue
+		}
+		if err := v.Validate(fmt.Sprintf("ci.storage[%d]", i)); err != nil {
+			me.add("", err)
+		}
+	}
+	me.add("", validateStorageSet("ci.storage", c.Storage))
+	for i, svc := range c.Services {
+		if svc == nil {
+			continue
+		}
+		if err := svc.Validate(fmt.Sprintf("ci.services[%d]", i)); err != nil {
+			me.add("", err)
+		}
+	}
+	me.add("", validateServiceSet("ci.services", c.Services, c.Interfaces))
+	return me
 }
 
 func (v *Version) Validate(path string) error {
@@ -220,18 +317,89 @@ func (i *Interface) Validate(path string) error {
 	me.add(path+".vlan", validateVLAN(path+".vlan", i.VLAN))
 	me.add(path+".ip", validateIP(path+".ip", i.IP))
 	me.add(path+".subnet", validateSubnetMask(path+".subnet", i.Subnet))
+	me.add(path+".gateway", validateIP(path+".gateway", i.Gateway))
+	me.add(path+".addresses", validateCIDRList(path+".addresses", i.Addresses))
+	me.add(path+".ipv6_mode", validateIPv6Mode(path+".ipv6_mode", i.IPv6Mode))
+	me.add(path+".gateway6", validateIP(path+".gateway6", i.Gateway6))
 	me.add(path+".dns", validateIPList(path+".dns", i.DNS))
 
-	if i.DHCP != nil && !boolOrFalse(i.DHCP) {
-		if isEmpty(i.IP) && isEmpty(i.Subnet) {
-			_ = errors.New("dhcp=false but ip/subnet not provided")
+	addrs := interfaceAddrs(path, i)
+	var hasIPv4, hasIPv6 bool
+	for _, a := range addrs {
+		if a.network == nil {
+			continue
+		}
+		if a.isIPv4() {
+			hasIPv4 = true
+		} else {
+			hasIPv6 = true
+		}
+	}
+
+	switch {
+	case i.DHCP == nil:
+	case *i.DHCP:
+		if !isEmpty(i.IP) {
+			me.add(path+".ip", errorf(CodeDHCPIPSet, "dhcp=true but ip is set"))
+		}
+		if !isEmpty(i.Subnet) {
+			me.add(path+".subnet", errorf(CodeDHCPSubnetSet, "dhcp=true but subnet is set"))
+		}
+		for _, a := range addrs {
+			if a.path != path+".ip" && a.isIPv4() {
+				me.add(a.path, errorf(CodeDHCPIPSet, "dhcp=true but IPv4 address %s is set", a.ip))
+			}
+		}
+	default:
+		// Static addresses are configured by ip and subnet, or by
+		// addresses. An IPv6-only interface needs no IPv4 address.
+		if !hasIPv4 && !hasIPv6 || !isEmpty(i.IP) || !isEmpty(i.Subnet) {
+			if isEmpty(i.IP) {
+				me.add(path+".ip", errorf(CodeStaticIPMissing, "dhcp=false but ip not provided"))
+			}
+			if isEmpty(i.Subnet) {
+				me.add(path+".subnet", errorf(CodeStaticSubnetMissing, "dhcp=false but subnet not provided"))
+			}
+		}
+	}
+
+	switch strOrEmpty(i.IPv6Mode) {
+	case IPv6ModeStatic:
+		if !hasIPv6 {
+			me.add(path+".addresses", errorf(CodeIPv6StaticMissing, "ipv6-mode=static but no IPv6 address provided"))
+		}
+	case IPv6ModeSLAAC, IPv6ModeDHCPv6:
+		for _, a := range addrs {
+			if !a.isIPv4() && !a.ip.IsLinkLocalUnicast() {
+				me.add(a.path, errorf(CodeIPv6AddressSet, "ipv6-mode=%s but IPv6 address %s is set", strOrEmpty(i.IPv6Mode), a.ip))
+			}
+		}
+	}
+
+	for _, a := range addrs {
+		if a.network == nil || !a.isIPv4() {
+			continue
+		}
+		// /31 and /32 have no network and broadcast address.
+		if ones, _ := a.network.Mask.Size(); ones <= 30 {
+			if a.ip.Equal(a.network.IP) {
+				me.add(a.path, errorf(CodeIPNetworkAddress, "ip %s is the network address of %s", a.ip, a.network))
+			} else if a.ip.Equal(broadcastAddress(a.network)) {
+				me.add(a.path, errorf(CodeIPBroadcastAddress, "ip %s is the broadcast address of %s", a.ip, a.network))
+			}
 		}
 	}
+	me.add(path+".gateway", validateGateway(net.ParseIP(strOrEmpty(i.Gateway)), true, addrs))
+	me.add(path+".gateway6", validateGateway(net.ParseIP(strOrEmpty(i.Gateway6)), false, addrs))
 
 	if s := strOrEmpty(i.Subnet); strings.HasPrefix(s, "/") && len(s) > 1 {
+		bits := 32
+		if ip := net.ParseIP(strOrEmpty(i.IP)); ip != nil && ip.To4() == nil {
+			bits = 128
+		}
 		if n, err := strconv.Atoi(strings.TrimPrefix(s, "/")); err == nil {
-			if n < 0 || n > 32 {
-				me.add(path+".subnet", fmt.Errorf("subnet prefix out of range /%d", n))
+			if n < 0 || n > bits {
+				me.add(path+".subnet", errorf(CodeSubnetPrefixRange, "subnet prefix out of range /%d (max /%d)", n, bits))
 			}
 		}
 	}
@@ -239,22 +407,73 @@ func (i *Interface) Validate(path string) error {
 	return me.ToError()
 }
 
+func (v *Volume) Validate(path string) error {
+	var me MultiError
+	me.add(path+".size", validatePositiveInt(path+".size", v.Size))
+	me.add(path+".mount", validateMount(path+".mount", v.Mount))
+	return me.ToError()
+}
+
+func (s *Service) Validate(path string) error {
+	var me MultiError
+	me.add(path+".port", validatePort(path+".port", s.Port))
+	me.add(path+".protocol", validateProtocol(path+".protocol", s.Protocol))
+	return me.ToError()
+}
+
+// DecodeYaml decodes a YAML document, rejecting unknown and duplicate keys.
+// The document is kept to locate findings by line and column. Documents
+// read this way can't extend others, see DecodeYamlFile.
 func DecodeYaml(r io.Reader) (*Root, error) {
 	b, err := io.ReadAll(r)
 	if err != nil {
 		return nil, err
 	}
-	var root Root
-	if err := yaml.Unmarshal(b, &root); err != nil {
+	var l layer
+	if err := decodeYamlStrict(b, &l); err != nil {
 		return nil, err
 	}
-	return &root, nil
+	if len(l.Extends) > 0 {
+		return nil, errors.New("extends is only supported in files")
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		return nil, err
+	}
+	if len(doc.Content) > 0 {
+		l.Root.source = doc.Content[0]
+	}
+	return &l.Root, nil
+}
+
+// decodeYamlStrict decodes b into v, rejecting unknown keys. An empty
+// document leaves v unchanged.
+func decodeYamlStrict(b []byte, v any) error {
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	if err := dec.Decode(v); err != nil && err != io.EOF {
+		return err
+	}
+	return nil
 }
 
+// DecodeJson decodes a JSON document, rejecting unknown keys. The
+// deprecated camelCase keys of earlier versions, like authorCompany, are
+// still accepted.
 func DecodeJson(r io.Reader) (*Root, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var v any
+	if err := json.Unmarshal(b, &v); err == nil && renameLegacyJSONKeys(v, reflect.TypeOf(Root{})) {
+		if b, err = json.Marshal(v); err != nil {
+			return nil, err
+		}
+	}
 
 	var root Root
-	decoder := json.NewDecoder(r)
+	decoder := json.NewDecoder(bytes.NewReader(b))
 	decoder.DisallowUnknownFields()
 	if err := decoder.Decode(&root); err != nil {
 		return nil, err
@@ -262,3 +481,40 @@ func DecodeJson(r io.Reader) (*Root, error) {
 
 	return &root, nil
 }
+
+// DecodeJsonFile decodes the JSON document at path after interpolating
+// ${NAME} and ${file:PATH} references in its strings.
+func DecodeJsonFile(path string) (*Root, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var v any
+	if err := json.Unmarshal(b, &v); err != nil {
+		return nil, err
+	}
+	if v, err = interpolateJSON(v, filepath.Dir(path)); err != nil {
+		return nil, err
+	}
+	if b, err = json.Marshal(v); err != nil {
+		return nil, err
+	}
+	return DecodeJson(bytes.NewReader(b))
+}
+
+// EncodeYaml writes root as a YAML document.
+func EncodeYaml(w io.Writer, root *Root) error {
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(root); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// EncodeJson writes root as an indented JSON document.
+func EncodeJson(w io.Writer, root *Root) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(root)
+}
@@ -0,0 +1,182 @@
+package pkg
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+type Severity string
+
+const (
+	SeverityError   Severity = "error"
+	SeverityWarning Severity = "warning"
+	SeverityInfo    Severity = "info"
+)
+
+// AtLeast reports whether s is as severe as min or more.
+func (s Severity) AtLeast(min Severity) bool {
+	return s.rank() >= min.rank()
+}
+
+func (s Severity) rank() int {
+	switch s {
+	case SeverityError:
+		return 2
+	case SeverityWarning:
+		return 1
+	}
+	return 0
+}
+
+// Diagnostic is a single finding reported by the TeX engine.
+type Diagnostic struct {
+	File     string   `json:"file,omitempty"`
+	Line     int      `json:"line,omitempty"`
+	Severity Severity `json:"severity"`
+	Message  string   `json:"message"`
+	Context  string   `json:"context,omitempty"`
+
+	// TemplateLine and Source locate the diagnostic in the template when
+	// it was reported against the rendered document.
+	TemplateLine int    `json:"templateLine,omitempty"`
+	Source       string `json:"source,omitempty"`
+}
+
+func (d Diagnostic) String() string {
+	var b strings.Builder
+	if d.Source != "" {
+		b.WriteString(d.Source)
+		b.WriteString(":")
+	} else if d.File != "" {
+		b.WriteString(d.File)
+		b.WriteString(":")
+	}
+	if d.Source == "" && d.Line > 0 {
+		b.WriteString(strconv.Itoa(d.Line))
+		b.WriteString(":")
+	}
+	if b.Len() > 0 {
+		b.WriteString(" ")
+	}
+	fmt.Fprintf(&b, "%s: %s", d.Severity, d.Message)
+	return b.String()
+}
+
+// CompileError is returned by CompileTeX when the TeX engine fails.
+type CompileError struct {
+	Err         error
+	Diagnostics []Diagnostic
+	Log         string
+}
+
+func (e *CompileError) Error() string {
+	var b strings.Builder
+	b.WriteString(e.Err.Error())
+	n := 0
+	for _, d := range e.Diagnostics {
+		if d.Severity != SeverityError {
+			continue
+		}
+		b.WriteString("\n")
+		b.WriteString(d.String())
+		n++
+	}
+	if n == 0 && e.Log != "" {
+		b.WriteString("\n")
+		b.WriteString(tail(e.Log, 2000))
+	}
+	return b.String()
+}
+
+func (e *CompileError) Unwrap() error { return e.Err }
+
+var (
+	reFileLineError = regexp.MustCompile(`^(\S.*?):(\d+): (.*)$`)
+	reContextLine   = regexp.MustCompile(`^l\.(\d+)(.*)$`)
+	reWarning       = regexp.MustCompile(`^(?:LaTeX|Package (\S+)|Class (\S+))(?: \S+)? Warning: (.*)$`)
+	reInputLine     = regexp.MustCompile(`on input line (\d+)\.?`)
+	reBadBox        = regexp.MustCompile(`^((?:Over|Under)full \\[hv]box .*?)(?: at lines? (\d+)(?:--\d+)?)?$`)
+)
+
+// ParseTeXLog extracts diagnostics from output produced by a TeX engine run
+// with -file-line-error.
+func ParseTeXLog(log string) []Diagnostic {
+	lines := strings.Split(strings.ReplaceAll(log, "\r\n", "\n"), "\n")
+	var diags []Diagnostic
+
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+
+		if m := reFileLineError.FindStringSubmatch(line); m != nil && looksLikeTeXFile(m[1]) {
+			n, _ := strconv.Atoi(m[2])
+			d := Diagnostic{
+				File:     strings.TrimPrefix(m[1], "./"),
+				Line:     n,
+				Severity: SeverityError,
+				Message:  strings.TrimSpace(m[3]),
+			}
+			// The engine follows an error with "l.<n> <source>" and the
+			// remainder of the offending source line on the next line.
+			for j := i + 1; j < len(lines) && j <= i+8; j++ {
+				if c := reContextLine.FindStringSubmatch(lines[j]); c != nil {
+					ctx := strings.TrimSpace(c[2])
+					if j+1 < len(lines) {
+						if rest := strings.TrimSpace(lines[j+1]); rest != "" {
+							ctx += " " + rest
+						}
+					}
+					d.Context = ctx
+					i = j + 1
+					break
+				}
+			}
+			diags = append(diags, d)
+			continue
+		}
+
+		if m := reWarning.FindStringSubmatch(line); m != nil {
+			msg := m[3]
+			pkgPrefix := "(" + m[1] + m[2] + ")"
+		continuation:
+			for i+1 < len(lines) {
+				next := lines[i+1]
+				switch {
+				case pkgPrefix != "()" && strings.HasPrefix(next, pkgPrefix):
+					next = strings.TrimPrefix(next, pkgPrefix)
+				case len(lines[i]) >= 79 && strings.TrimSpace(next) != "":
+					// The engine hard wraps log lines at 79 characters.
+				default:
+					break continuation
+				}
+				i++
+				msg += " " + strings.TrimSpace(next)
+			}
+			d := Diagnostic{Severity: SeverityWarning, Message: strings.TrimSpace(msg)}
+			if im := reInputLine.FindStringSubmatch(msg); im != nil {
+				d.Line, _ = strconv.Atoi(im[1])
+			}
+			diags = append(diags, d)
+			continue
+		}
+
+		if m := reBadBox.FindStringSubmatch(line); m != nil {
+			d := Diagnostic{Severity: SeverityInfo, Message: strings.TrimSpace(line)}
+			if m[2] != "" {
+				d.Line, _ = strconv.Atoi(m[2])
+			}
+			diags = append(diags, d)
+		}
+	}
+	return diags
+}
+
+func looksLikeTeXFile(name string) bool {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".tex", ".sty", ".cls", ".def", ".cfg", ".fd", ".clo", ".ltx", ".bbl", ".aux", ".toc":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,70 @@
+package pkg
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseTeXLog(t *testing.T) {
+	tests := []struct {
+		name string
+		log  string
+		want []Diagnostic
+	}{
+		{
+			name: "error with context",
+			log: "(./document.tex\n" +
+				"./document.tex:42: Undefined control sequence.\n" +
+				"l.42 \\foo\n" +
+				"          {bar}\n",
+			want: []Diagnostic{{File: "document.tex", Line: 42, Severity: SeverityError, Message: "Undefined control sequence.", Context: `\foo {bar}`}},
+		},
+		{
+			name: "error without context",
+			log:  "./document.tex:7: LaTeX Error: Environment foo undefined.\n",
+			want: []Diagnostic{{File: "document.tex", Line: 7, Severity: SeverityError, Message: "LaTeX Error: Environment foo undefined."}},
+		},
+		{
+			name: "not a TeX file",
+			log:  "some/path.go:12: not from TeX\n",
+		},
+		{
+			name: "LaTeX warning",
+			log:  "LaTeX Warning: Reference `fig' on page 1 undefined on input line 12.\n",
+			want: []Diagnostic{{Line: 12, Severity: SeverityWarning, Message: "Reference `fig' on page 1 undefined on input line 12."}},
+		},
+		{
+			name: "package warning continued",
+			log: "Package hyperref Warning: Token not allowed in a PDF string\n" +
+				"(hyperref)                removing `\\textbf' on input line 30.\n" +
+				"\n",
+			want: []Diagnostic{{Line: 30, Severity: SeverityWarning, Message: "Token not allowed in a PDF string removing `\\textbf' on input line 30."}},
+		},
+		{
+			name: "wrapped warning",
+			log: "LaTeX Font Warning: Font shape `OT1/cmr/bx/sc' undefined using `OT1/cmr/bx/n' ins\n" +
+				"tead on input line 9.\n",
+			want: []Diagnostic{{Line: 9, Severity: SeverityWarning, Message: "Font shape `OT1/cmr/bx/sc' undefined using `OT1/cmr/bx/n' ins tead on input line 9."}},
+		},
+		{
+			name: "bad box",
+			log:  "Overfull \\hbox (12.0pt too wide) in paragraph at lines 20--22\n",
+			want: []Diagnostic{{Line: 20, Severity: SeverityInfo, Message: "Overfull \\hbox (12.0pt too wide) in paragraph at lines 20--22"}},
+		},
+		{
+			name: "bad box without line",
+			log:  "Underfull \\vbox (badness 10000) has occurred while \\output is active\n",
+			want: []Diagnostic{{Severity: SeverityInfo, Message: "Underfull \\vbox (badness 10000) has occurred while \\output is active"}},
+		},
+		{
+			name: "CRLF",
+			log:  "./a.tex:3: Missing $ inserted.\r\nl.3 x_\r\n     y\r\n",
+			want: []Diagnostic{{File: "a.tex", Line: 3, Severity: SeverityError, Message: "Missing $ inserted.", Context: "x_ y"}},
+		},
+	}
+	for _, tt := range tests {
+		if got := ParseTeXLog(tt.log); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %#v, want %#v", tt.name, got, tt.want)
+		}
+	}
+}
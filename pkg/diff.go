@@ -0,0 +1,154 @@
+package pkg
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+type ChangeKind string
+
+const (
+	ChangeAdded   ChangeKind = "added"
+	ChangeRemoved ChangeKind = "removed"
+	ChangeChanged ChangeKind = "changed"
+)
+
+// Change is a single field-level difference between two CIs. Path is a
+// validation path like "ci.interfaces[0].dns[1]" or "ci.description.disaster_lvl".
+type Change struct {
+	Path string     `json:"path"`
+	Kind ChangeKind `json:"kind"`
+	Old  string     `json:"old,omitempty"`
+	New  string     `json:"new,omitempty"`
+}
+
+func (c Change) String() string {
+	switch c.Kind {
+	case ChangeAdded:
+		if c.New != "" {
+			return fmt.Sprintf("%s added (%s)", c.Path, c.New)
+		}
+		return c.Path + " added"
+	case ChangeRemoved:
+		if c.Old != "" {
+			return fmt.Sprintf("%s removed (%s)", c.Path, c.Old)
+		}
+		return c.Path + " removed"
+	default:
+		return fmt.Sprintf("%s: %s → %s", c.Path, c.Old, c.New)
+	}
+}
+
+// DiffCI returns the field-level changes from old to new. Added or removed
+// list entries and sections are reported once and not field by field.
+func DiffCI(old, new *CI) []Change {
+	if old == nil {
+		old = &CI{}
+	}
+	if new == nil {
+		new = &CI{}
+	}
+	var changes []Change
+	diffValue(&changes, "ci", reflect.ValueOf(old).Elem(), reflect.ValueOf(new).Elem())
+	return changes
+}
+
+func diffValue(changes *[]Change, path string, a, b reflect.Value) {
+	if a.Kind() == reflect.Pointer {
+		switch {
+		case a.IsNil() && b.IsNil():
+			return
+		case a.IsNil():
+			*changes = append(*changes, Change{Path: path, Kind: ChangeAdded, New: formatValue(b)})
+			return
+		case b.IsNil():
+			*changes = append(*changes, Change{Path: path, Kind: ChangeRemoved, Old: formatValue(a)})
+			return
+		}
+		a, b = a.Elem(), b.Elem()
+	}
+
+	switch a.Kind() {
+	case reflect.Struct:
+		t := a.Type()
+		for i := 0; i < t.NumField(); i++ {
+			name := yamlFieldName(t.Field(i))
+			if name == "" {
+				continue
+			}
+			n := len(*changes)
+			diffValue(changes, path+"."+pathName(name), a.Field(i), b.Field(i))
+			if isSensitive(t.Field(i)) {
+				redactChanges((*changes)[n:])
+			}
+		}
+	case reflect.Slice:
+		for i := 0; i < max(a.Len(), b.Len()); i++ {
+			p := fmt.Sprintf("%s[%d]", path, i)
+			switch {
+			case i >= a.Len():
+				if !isNilValue(b.Index(i)) {
+					*changes = append(*changes, Change{Path: p, Kind: ChangeAdded, New: formatValue(b.Index(i))})
+				}
+			case i >= b.Len():
+				if !isNilValue(a.Index(i)) {
+					*changes = append(*changes, Change{Path: p, Kind: ChangeRemoved, Old: formatValue(a.Index(i))})
+				}
+			default:
+				diffValue(changes, p, a.Index(i), b.Index(i))
+			}
+		}
+	default:
+		if !reflect.DeepEqual(a.Interface(), b.Interface()) {
+			*changes = append(*changes, Change{Path: path, Kind: ChangeChanged, Old: formatValue(a), New: formatValue(b)})
+		}
+	}
+}
+
+// formatValue formats scalar values; sections and lists are left empty.
+func formatValue(v reflect.Value) string {
+	for v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return ""
+		}
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Struct, reflect.Slice, reflect.Map:
+		return ""
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+// redactChanges hides the values of changes to sensitive fields.
+func redactChanges(changes []Change) {
+	for i := range changes {
+		if changes[i].Old != "" {
+			changes[i].Old = Redacted
+		}
+		if changes[i].New != "" {
+			changes[i].New = Redacted
+		}
+	}
+}
+
+func isNilValue(v reflect.Value) bool {
+	return v.Kind() == reflect.Pointer && v.IsNil()
+}
+
+// yamlFieldName returns the YAML key of a struct field, or "" if the field
+// is not serialized.
+func yamlFieldName(f reflect.StructField) string {
+	if !f.IsExported() {
+		return ""
+	}
+	name, _, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+	switch name {
+	case "-":
+		return ""
+	case "":
+		return strings.ToLower(f.Name)
+	}
+	return name
+}
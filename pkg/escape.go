@@ -0,0 +1,131 @@
+package pkg
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"text/template/parse"
+)
+
+// LaTeX marks a string as trusted LaTeX source which is written to the
+// rendered document verbatim instead of being escaped.
+type LaTeX string
+
+const escapeFuncName = "_texEscape"
+
+var texReplacer = strings.NewReplacer(
+	`\`, `\textbackslash{}`,
+	`{`, `\{`,
+	`}`, `\}`,
+	`&`, `\&`,
+	`%`, `\%`,
+	`$`, `\$`,
+	`#`, `\#`,
+	`_`, `\_`,
+	`~`, `\textasciitilde{}`,
+	`^`, `\textasciicircum{}`,
+	"\x00", "",
+)
+
+// EscapeTeX escapes all characters which have a special meaning in LaTeX.
+func EscapeTeX(s string) string {
+	return texReplacer.Replace(s)
+}
+
+func rawTeX(v any) LaTeX {
+	if l, ok := v.(LaTeX); ok {
+		return l
+	}
+	return LaTeX(printable(v))
+}
+
+func texEscape(v any) string {
+	if l, ok := v.(LaTeX); ok {
+		return strings.ReplaceAll(string(l), "\x00", "")
+	}
+	return EscapeTeX(printable(v))
+}
+
+// printable formats v like text/template would, except that nil pointers
+// render as an empty string.
+func printable(v any) string {
+	rv := reflect.ValueOf(v)
+	for rv.IsValid() && (rv.Kind() == reflect.Pointer || rv.Kind() == reflect.Interface) {
+		if rv.IsNil() {
+			return ""
+		}
+		if _, ok := rv.Interface().(fmt.Stringer); ok {
+			break
+		}
+		rv = rv.Elem()
+	}
+	if !rv.IsValid() {
+		return ""
+	}
+	return fmt.Sprint(rv.Interface())
+}
+
+// deref returns the value v points to, or nil for a nil pointer, so that
+// `<< if deref .DHCP >>` tests the value instead of whether it is set.
+func deref(v any) any {
+	rv := reflect.ValueOf(v)
+	for rv.IsValid() && rv.Kind() == reflect.Pointer {
+		if rv.IsNil() {
+			return nil
+		}
+		rv = rv.Elem()
+	}
+	if !rv.IsValid() {
+		return nil
+	}
+	return rv.Interface()
+}
+
+// autoEscape appends the escape function to every action in the tree which
+// writes to the output, so that `<< .Field >>` is always LaTeX escaped.
+func autoEscape(t *parse.Tree) {
+	if t == nil || t.Root == nil {
+		return
+	}
+	escapeList(t.Root)
+}
+
+func escapeList(l *parse.ListNode) {
+	if l == nil {
+		return
+	}
+	for _, n := range l.Nodes {
+		switch n := n.(type) {
+		case *parse.ActionNode:
+			escapeAction(n)
+		case *parse.IfNode:
+			escapeList(n.List)
+			escapeList(n.ElseList)
+		case *parse.RangeNode:
+			escapeList(n.List)
+			escapeList(n.ElseList)
+		case *parse.WithNode:
+			escapeList(n.List)
+			escapeList(n.ElseList)
+		}
+	}
+}
+
+func escapeAction(a *parse.ActionNode) {
+	if a.Pipe == nil || len(a.Pipe.Decl) > 0 {
+		// Variable declarations and assignments produce no output.
+		return
+	}
+	cmds := a.Pipe.Cmds
+	if len(cmds) > 0 {
+		if id, ok := cmds[len(cmds)-1].Args[0].(*parse.IdentifierNode); ok && id.Ident == escapeFuncName {
+			return
+		}
+	}
+	ident := parse.NewIdentifier(escapeFuncName).SetPos(a.Pos)
+	a.Pipe.Cmds = append(cmds, &parse.CommandNode{
+		NodeType: parse.NodeCommand,
+		Pos:      a.Pos,
+		Args:     []parse.Node{ident},
+	})
+}
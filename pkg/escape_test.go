@@ -0,0 +1,58 @@
+package pkg
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAutoEscape(t *testing.T) {
+	name := `a&b_c%d$e#f{g}h~i^j\k`
+	root := Root{CI: &CI{Configuration: &Configuration{Name: &name}}}
+	const escaped = `a\&b\_c\%d\$e\#f\{g\}h\textasciitilde{}i\textasciicircum{}j\textbackslash{}k`
+	tests := []struct {
+		name, tmpl, want string
+	}{
+		{"field", `<< .CI.Configuration.Name >>`, escaped},
+		// Escaping applies to the result of the pipeline.
+		{"pipeline", `<< .CI.Configuration.Name | upper >>`, EscapeTeX(strings.ToUpper(name))},
+		{"literal", `<< "50% & more" >>`, `50\% \& more`},
+		{"text untouched", `\section{x} << "_" >>`, `\section{x} \_`},
+		{"raw", `<< raw "\\textbf{x}" >>`, `\textbf{x}`},
+		{"latex", `<< latex "$x^2$" >>`, `$x^2$`},
+		{"raw of field", `<< raw .CI.Configuration.Name >>`, name},
+		{"if", `<< if .CI.Configuration.Name >><< .CI.Configuration.Name >><< end >>`, escaped},
+		{"range", `<< range $s := .CI.Configuration.NTP >><< $s >><< else >>#none<< end >>`, `#none`},
+		{"with", `<< with .CI.Configuration >><< .Name >><< end >>`, escaped},
+		{"variable", `<< $n := .CI.Configuration.Name >><< $n >>`, escaped},
+		{"define and template", `<< define "n" >>[<< . >>]<< end >><< template "n" .CI.Configuration.Name >>`, "[" + escaped + "]"},
+		{"template of raw", `<< define "n" >><< . >><< end >><< template "n" (raw "\\\\") >>`, `\\`},
+		{"nil pointer", `<< .CI.Configuration.FQDN >>`, ""},
+	}
+	for _, tt := range tests {
+		out, _, err := ParseTempl(strings.NewReader(tt.tmpl), root, true)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if got := string(out); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestEscapeTeX(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"plain text", "plain text"},
+		{`C:\Temp`, `C:\textbackslash{}Temp`},
+		{"100%", `100\%`},
+		{"a\x00b", "ab"},
+		{"ümlaut_ß", `ümlaut\_ß`},
+	}
+	for _, tt := range tests {
+		if got := EscapeTeX(tt.in); got != tt.want {
+			t.Errorf("EscapeTeX(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
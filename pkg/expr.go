@@ -0,0 +1,570 @@
+package pkg
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// expr is a compiled policy expression. Expressions are evaluated on the
+// CI in its generic form: maps keyed by YAML names, lists and scalars.
+//
+//	zone == "DMZ" && vlan >= 300 && vlan <= 399
+//	len(audit-versions) > 0
+//	configuration.os =~ "^Windows Server" || !exists(configuration.ram)
+//
+// Identifiers are dotted YAML paths which may contain hyphens, so there is
+// no arithmetic. Missing values are null and compare false with < and >.
+type expr interface {
+	eval(s *exprScope) (any, error)
+}
+
+// exprScope resolves identifiers against the current item, or the CI as a
+// whole with the "ci." prefix.
+type exprScope struct {
+	item map[string]any
+	ci   map[string]any
+}
+
+func (s *exprScope) lookup(path []string) any {
+	var v any = s.item
+	if path[0] == "ci" && len(path) > 1 {
+		v, path = s.ci, path[1:]
+	}
+	for _, name := range path {
+		m, ok := v.(map[string]any)
+		if !ok {
+			return nil
+		}
+		v = m[name]
+	}
+	return v
+}
+
+type (
+	literalExpr struct{ value any }
+	pathExpr    struct{ path []string }
+	listExpr    struct{ items []expr }
+	notExpr     struct{ x expr }
+	callExpr    struct {
+		name string
+		args []expr
+	}
+	binaryExpr struct {
+		op   string
+		l, r expr
+		re   *regexp.Regexp
+	}
+)
+
+func (e *literalExpr) eval(*exprScope) (any, error) { return e.value, nil }
+
+func (e *pathExpr) eval(s *exprScope) (any, error) { return s.lookup(e.path), nil }
+
+func (e *listExpr) eval(s *exprScope) (any, error) {
+	list := make([]any, 0, len(e.items))
+	for _, item := range e.items {
+		v, err := item.eval(s)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, v)
+	}
+	return list, nil
+}
+
+func (e *notExpr) eval(s *exprScope) (any, error) {
+	v, err := e.x.eval(s)
+	if err != nil {
+		return nil, err
+	}
+	return !truthy(v), nil
+}
+
+func (e *callExpr) eval(s *exprScope) (any, error) {
+	args := make([]any, 0, len(e.args))
+	for _, a := range e.args {
+		v, err := a.eval(s)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, v)
+	}
+	switch e.name {
+	case "exists":
+		return args[0] != nil, nil
+	case "len":
+		switch v := args[0].(type) {
+		case nil:
+			return 0.0, nil
+		case string:
+			return float64(len(v)), nil
+		case []any:
+			return float64(len(v)), nil
+		case map[string]any:
+			return float64(len(v)), nil
+		}
+		return nil, fmt.Errorf("len of %s", typeName(args[0]))
+	case "lower":
+		str, _ := args[0].(string)
+		return strings.ToLower(str), nil
+	case "contains":
+		switch v := args[0].(type) {
+		case nil:
+			return false, nil
+		case string:
+			sub, ok := args[1].(string)
+			if !ok {
+				return nil, fmt.Errorf("contains of %s in string", typeName(args[1]))
+			}
+			return strings.Contains(v, sub), nil
+		case []any:
+			for _, item := range v {
+				if equalValues(item, args[1]) {
+					return true, nil
+				}
+			}
+			return false, nil
+		}
+		return nil, fmt.Errorf("contains on %s", typeName(args[0]))
+	}
+	return nil, fmt.Errorf("unknown function %s", e.name)
+}
+
+func (e *binaryExpr) eval(s *exprScope) (any, error) {
+	l, err := e.l.eval(s)
+	if err != nil {
+		return nil, err
+	}
+	switch e.op {
+	case "&&":
+		if !truthy(l) {
+			return false, nil
+		}
+	case "||":
+		if truthy(l) {
+			return true, nil
+		}
+	}
+	r, err := e.r.eval(s)
+	if err != nil {
+		return nil, err
+	}
+
+	switch e.op {
+	case "&&", "||":
+		return truthy(r), nil
+	case "==":
+		return equalValues(l, r), nil
+	case "!=":
+		return !equalValues(l, r), nil
+	case "in":
+		list, ok := r.([]any)
+		if !ok && r != nil {
+			return nil, fmt.Errorf("in needs a list, got %s", typeName(r))
+		}
+		for _, item := range list {
+			if equalValues(l, item) {
+				return true, nil
+			}
+		}
+		return false, nil
+	case "=~":
+		if l == nil {
+			return false, nil
+		}
+		str, ok := l.(string)
+		if !ok {
+			return nil, fmt.Errorf("=~ needs a string, got %s", typeName(l))
+		}
+		re := e.re
+		if re == nil {
+			pattern, ok := r.(string)
+			if !ok {
+				return nil, fmt.Errorf("=~ needs a string pattern, got %s", typeName(r))
+			}
+			if re, err = regexp.Compile(pattern); err != nil {
+				return nil, err
+			}
+		}
+		return re.MatchString(str), nil
+	}
+
+	// Ordering comparisons.
+	if l == nil || r == nil {
+		return false, nil
+	}
+	var c int
+	if lf, ok := toFloat(l); ok {
+		rf, ok := toFloat(r)
+		if !ok {
+			return nil, fmt.Errorf("can't compare number with %s", typeName(r))
+		}
+		c = compareFloats(lf, rf)
+	} else if ls, ok := l.(string); ok {
+		rs, ok := r.(string)
+		if !ok {
+			return nil, fmt.Errorf("can't compare string with %s", typeName(r))
+		}
+		c = strings.Compare(ls, rs)
+	} else {
+		return nil, fmt.Errorf("can't compare %s", typeName(l))
+	}
+	switch e.op {
+	case "<":
+		return c < 0, nil
+	case "<=":
+		return c <= 0, nil
+	case ">":
+		return c > 0, nil
+	default:
+		return c >= 0, nil
+	}
+}
+
+func truthy(v any) bool {
+	switch v := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case string:
+		return v != ""
+	case []any:
+		return len(v) > 0
+	case map[string]any:
+		return len(v) > 0
+	}
+	if f, ok := toFloat(v); ok {
+		return f != 0
+	}
+	return true
+}
+
+func equalValues(a, b any) bool {
+	if af, ok := toFloat(a); ok {
+		bf, ok := toFloat(b)
+		return ok && af == bf
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func toFloat(v any) (float64, bool) {
+	switch v := v.(type) {
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+func compareFloats(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func typeName(v any) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "string"
+	case bool:
+		return "bool"
+	case []any:
+		return "list"
+	case map[string]any:
+		return "section"
+	}
+	if _, ok := toFloat(v); ok {
+		return "number"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+// functions maps the expression functions to their number of arguments.
+var functions = map[string]int{
+	"exists":   1,
+	"len":      1,
+	"lower":    1,
+	"contains": 2,
+}
+
+type token struct {
+	kind byte // 'i'dentifier, 'n'umber, 's'tring, 'p'unctuation or 0 at the end
+	text string
+	pos  int
+}
+
+func lexExpr(src string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(src); {
+		c, size := utf8.DecodeRuneInString(src[i:])
+		switch {
+		case c == utf8.RuneError && size == 1:
+			return nil, fmt.Errorf("invalid UTF-8 at %d", i)
+		case unicode.IsSpace(c):
+			i += size
+		case unicode.IsLetter(c) || c == '_':
+			j := i + size
+			for j < len(src) {
+				r, n := utf8.DecodeRuneInString(src[j:])
+				if !isIdentRune(r) {
+					break
+				}
+				j += n
+			}
+			tokens = append(tokens, token{'i', src[i:j], i})
+			i = j
+		case isDigit(c):
+			j := i + 1
+			for j < len(src) && (isDigit(rune(src[j])) || src[j] == '.') {
+				j++
+			}
+			tokens = append(tokens, token{'n', src[i:j], i})
+			i = j
+		case c == '"':
+			j := i + 1
+			for j < len(src) && src[j] != '"' {
+				if src[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(src) {
+				return nil, fmt.Errorf("unterminated string at %d", i)
+			}
+			s, err := strconv.Unquote(src[i : j+1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid string at %d: %w", i, err)
+			}
+			tokens = append(tokens, token{'s', s, i})
+			i = j + 1
+		default:
+			op := ""
+			for _, candidate := range []string{"&&", "||", "==", "!=", "<=", ">=", "=~", "<", ">", "!", "(", ")", "[", "]", ","} {
+				if strings.HasPrefix(src[i:], candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("unexpected %q at %d", c, i)
+			}
+			tokens = append(tokens, token{'p', op, i})
+			i += len(op)
+		}
+	}
+	return append(tokens, token{pos: len(src)}), nil
+}
+
+// isIdentRune reports whether r may continue an identifier. Besides
+// letters and digits these are the characters of YAML names and paths.
+func isIdentRune(r rune) bool {
+	return r == '_' || r == '-' || r == '.' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// isDigit reports whether r is an ASCII digit, the only ones numbers may have.
+func isDigit(r rune) bool {
+	return r >= '0' && r <= '9'
+}
+
+// parseExpr compiles a policy expression.
+func parseExpr(src string) (expr, error) {
+	tokens, err := lexExpr(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &exprParser{tokens: tokens}
+	e, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != 0 {
+		return nil, fmt.Errorf("unexpected %q at %d", t.text, t.pos)
+	}
+	return e, nil
+}
+
+type exprParser struct {
+	tokens []token
+	i      int
+}
+
+func (p *exprParser) peek() token { return p.tokens[p.i] }
+
+func (p *exprParser) next() token {
+	t := p.tokens[p.i]
+	if t.kind != 0 {
+		p.i++
+	}
+	return t
+}
+
+func (p *exprParser) accept(punct string) bool {
+	if t := p.peek(); t.kind == 'p' && t.text == punct {
+		p.i++
+		return true
+	}
+	return false
+}
+
+func (p *exprParser) expect(punct string) error {
+	if !p.accept(punct) {
+		t := p.peek()
+		if t.kind == 0 {
+			return fmt.Errorf("expected %q at end", punct)
+		}
+		return fmt.Errorf("expected %q at %d, got %q", punct, t.pos, t.text)
+	}
+	return nil
+}
+
+func (p *exprParser) or() (expr, error) {
+	l, err := p.and()
+	for err == nil && p.accept("||") {
+		var r expr
+		if r, err = p.and(); err == nil {
+			l = &binaryExpr{op: "||", l: l, r: r}
+		}
+	}
+	return l, err
+}
+
+func (p *exprParser) and() (expr, error) {
+	l, err := p.not()
+	for err == nil && p.accept("&&") {
+		var r expr
+		if r, err = p.not(); err == nil {
+			l = &binaryExpr{op: "&&", l: l, r: r}
+		}
+	}
+	return l, err
+}
+
+func (p *exprParser) not() (expr, error) {
+	if p.accept("!") {
+		x, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		return &notExpr{x: x}, nil
+	}
+	return p.comparison()
+}
+
+func (p *exprParser) comparison() (expr, error) {
+	l, err := p.primary()
+	if err != nil {
+		return nil, err
+	}
+	t := p.peek()
+	isOp := t.kind == 'p' && strings.Contains(" == != < <= > >= =~ ", " "+t.text+" ")
+	if !isOp && !(t.kind == 'i' && t.text == "in") {
+		return l, nil
+	}
+	p.next()
+	r, err := p.primary()
+	if err != nil {
+		return nil, err
+	}
+	e := &binaryExpr{op: t.text, l: l, r: r}
+	if lit, ok := r.(*literalExpr); ok && e.op == "=~" {
+		pattern, ok := lit.value.(string)
+		if !ok {
+			return nil, fmt.Errorf("=~ needs a string pattern at %d", t.pos)
+		}
+		if e.re, err = regexp.Compile(pattern); err != nil {
+			return nil, err
+		}
+	}
+	return e, nil
+}
+
+func (p *exprParser) primary() (expr, error) {
+	t := p.next()
+	switch t.kind {
+	case 'n':
+		f, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q at %d", t.text, t.pos)
+		}
+		return &literalExpr{value: f}, nil
+	case 's':
+		return &literalExpr{value: t.text}, nil
+	case 'i':
+		switch t.text {
+		case "true":
+			return &literalExpr{value: true}, nil
+		case "false":
+			return &literalExpr{value: false}, nil
+		case "null":
+			return &literalExpr{value: nil}, nil
+		}
+		if !p.accept("(") {
+			return &pathExpr{path: strings.Split(t.text, ".")}, nil
+		}
+		n, ok := functions[t.text]
+		if !ok {
+			return nil, fmt.Errorf("unknown function %s at %d", t.text, t.pos)
+		}
+		args, err := p.list(")")
+		if err != nil {
+			return nil, err
+		}
+		if len(args) != n {
+			return nil, fmt.Errorf("%s takes %d arguments, got %d", t.text, n, len(args))
+		}
+		return &callExpr{name: t.text, args: args}, nil
+	case 'p':
+		switch t.text {
+		case "(":
+			e, err := p.or()
+			if err != nil {
+				return nil, err
+			}
+			return e, p.expect(")")
+		case "[":
+			items, err := p.list("]")
+			if err != nil {
+				return nil, err
+			}
+			return &listExpr{items: items}, nil
+		}
+		return nil, fmt.Errorf("unexpected %q at %d", t.text, t.pos)
+	}
+	return nil, fmt.Errorf("unexpected end of expression")
+}
+
+// list parses comma separated expressions up to the closing punctuation.
+func (p *exprParser) list(end string) ([]expr, error) {
+	var items []expr
+	if p.accept(end) {
+		return items, nil
+	}
+	for {
+		e, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, e)
+		if p.accept(end) {
+			return items, nil
+		}
+		if err := p.expect(","); err != nil {
+			return nil, err
+		}
+	}
+}
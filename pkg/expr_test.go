@@ -0,0 +1,142 @@
+package pkg
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLexExpr(t *testing.T) {
+	tests := []struct {
+		src  string
+		want []token
+	}{
+		{`zone == "DMZ"`, []token{{'i', "zone", 0}, {'p', "==", 5}, {'s', "DMZ", 8}, {0, "", 13}}},
+		{`len(audit-versions)>=1.5`, []token{{'i', "len", 0}, {'p', "(", 3}, {'i', "audit-versions", 4}, {'p', ")", 18}, {'p', ">=", 19}, {'n', "1.5", 21}, {0, "", 24}}},
+		{`ci.configuration.os =~ "^Win"`, []token{{'i', "ci.configuration.os", 0}, {'p', "=~", 20}, {'s', "^Win", 23}, {0, "", 29}}},
+		{`größe != "ä\"ö"`, []token{{'i', "größe", 0}, {'p', "!=", 8}, {'s', `ä"ö`, 11}, {0, "", 19}}},
+		{" !x", []token{{'p', "!", 2}, {'i', "x", 3}, {0, "", 4}}},
+	}
+	for _, tt := range tests {
+		got, err := lexExpr(tt.src)
+		if err != nil {
+			t.Errorf("lexExpr(%q): %v", tt.src, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("lexExpr(%q) = %v, want %v", tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestLexExprErrors(t *testing.T) {
+	tests := []struct {
+		src, err string
+	}{
+		{`zone == "DMZ`, "unterminated string at 8"},
+		{`zone == "\q"`, "invalid string at 8"},
+		{`a + b`, `unexpected '+' at 2`},
+		{`a == §`, `unexpected '§' at 5`},
+		{"a == \xff", "invalid UTF-8 at 5"},
+		{"٣ > 1", `unexpected '٣' at 0`},
+	}
+	for _, tt := range tests {
+		_, err := lexExpr(tt.src)
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("lexExpr(%q) error = %v, want %q", tt.src, err, tt.err)
+		}
+	}
+}
+
+func TestEvalExpr(t *testing.T) {
+	scope := &exprScope{
+		item: map[string]any{"zone": "DMZ", "vlan": 310, "tags": []any{"web", "db"}},
+		ci:   map[string]any{"configuration": map[string]any{"os": "Windows Server 2022", "ram": 16}},
+	}
+	tests := []struct {
+		src  string
+		want any
+	}{
+		// && binds tighter than ||, and ! tighter than both.
+		{`true || false && false`, true},
+		{`(true || false) && false`, false},
+		{`!false && false`, false},
+		{`!(false && false)`, true},
+		{`false && false || true`, true},
+		{`!!true`, true},
+		// Comparisons bind tighter than && and ||.
+		{`zone == "DMZ" && vlan >= 300 && vlan <= 399`, true},
+		{`zone == "LAN" || vlan > 300`, true},
+		{`!exists(missing) && missing < 1`, false},
+		{`zone in ["DMZ", "LAN"]`, true},
+		{`contains(tags, "db")`, true},
+		{`len(tags) == 2`, true},
+		{`lower(zone) == "dmz"`, true},
+		{`ci.configuration.os =~ "^Windows Server"`, true},
+		{`ci.configuration.ram > 8`, true},
+		{`missing == null`, true},
+	}
+	for _, tt := range tests {
+		e, err := parseExpr(tt.src)
+		if err != nil {
+			t.Errorf("parseExpr(%q): %v", tt.src, err)
+			continue
+		}
+		got, err := e.eval(scope)
+		if err != nil {
+			t.Errorf("eval(%q): %v", tt.src, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("eval(%q) = %v, want %v", tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestParseExprErrors(t *testing.T) {
+	tests := []struct {
+		src, err string
+	}{
+		{`size(tags) > 1`, "unknown function size at 0"},
+		{`len(a, b)`, "len takes 1 arguments, got 2"},
+		{`(a == 1`, `expected ")" at end`},
+		{`a == 1 b`, `unexpected "b" at 7`},
+		{`a ==`, "unexpected end of expression"},
+		{`a =~ 1`, "=~ needs a string pattern at 2"},
+		{`a =~ "("`, "missing closing )"},
+	}
+	for _, tt := range tests {
+		_, err := parseExpr(tt.src)
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("parseExpr(%q) error = %v, want %q", tt.src, err, tt.err)
+		}
+	}
+}
+
+func TestEvalExprErrors(t *testing.T) {
+	scope := &exprScope{item: map[string]any{"zone": "DMZ", "vlan": 310, "tags": []any{"web"}}}
+	tests := []struct {
+		src, err string
+	}{
+		{`len(vlan) > 0`, "len of number"},
+		{`contains(vlan, 1)`, "contains on number"},
+		{`contains(zone, 1)`, "contains of number in string"},
+		{`zone in "DMZ"`, "in needs a list, got string"},
+		{`vlan =~ "3"`, "=~ needs a string, got number"},
+		{`zone =~ vlan`, "=~ needs a string pattern, got number"},
+		{`vlan < "400"`, "can't compare number with string"},
+		{`zone < 1`, "can't compare string with number"},
+		{`tags < tags`, "can't compare list"},
+	}
+	for _, tt := range tests {
+		e, err := parseExpr(tt.src)
+		if err != nil {
+			t.Errorf("parseExpr(%q): %v", tt.src, err)
+			continue
+		}
+		_, err = e.eval(scope)
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("eval(%q) error = %v, want %q", tt.src, err, tt.err)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package pkg
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// reReference matches ${NAME} and ${file:PATH} references, and the escape
+// $${ for a literal ${.
+var reReference = regexp.MustCompile(`\$\$\{|\$\{([^}]*)\}`)
+
+// interpolate replaces ${NAME} in s by the environment variable NAME and
+// ${file:PATH} by the content of the file at PATH without its final line
+// break. Relative paths are relative to dir.
+func interpolate(s, dir string) (string, error) {
+	if !strings.Contains(s, "${") {
+		return s, nil
+	}
+	var err error
+	out := reReference.ReplaceAllStringFunc(s, func(ref string) string {
+		if ref == "$${" || err != nil {
+			return "${"
+		}
+		name := reReference.FindStringSubmatch(ref)[1]
+		if path, ok := strings.CutPrefix(name, "file:"); ok {
+			if !filepath.IsAbs(path) {
+				path = filepath.Join(dir, path)
+			}
+			b, rerr := os.ReadFile(path)
+			if rerr != nil {
+				err = fmt.Errorf("reading %s: %w", ref, rerr)
+				return ""
+			}
+			return strings.TrimRight(string(b), "\r\n")
+		}
+		v, ok := os.LookupEnv(name)
+		if !ok {
+			err = fmt.Errorf("environment variable %s referenced by %s is not set", name, ref)
+			return ""
+		}
+		return v
+	})
+	return out, err
+}
+
+// interpolateNode interpolates the scalar values of n. Plain scalars are
+// typed by their interpolated value, so ${RAM} may fill in a number.
+func interpolateNode(n *yaml.Node, dir string) error {
+	switch n.Kind {
+	case yaml.ScalarNode:
+		v, err := interpolate(n.Value, dir)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", n.Line, err)
+		}
+		if v != n.Value {
+			n.Value = v
+			if n.Style == 0 {
+				n.Tag = ""
+			}
+		}
+	case yaml.MappingNode:
+		for i := 1; i < len(n.Content); i += 2 {
+			if err := interpolateNode(n.Content[i], dir); err != nil {
+				return err
+			}
+		}
+	default:
+		for _, child := range n.Content {
+			if err := interpolateNode(child, dir); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// interpolateJSON interpolates the strings of a decoded JSON value.
+func interpolateJSON(v any, dir string) (any, error) {
+	switch v := v.(type) {
+	case string:
+		return interpolate(v, dir)
+	case map[string]any:
+		for k, e := range v {
+			ie, err := interpolateJSON(e, dir)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", k, err)
+			}
+			v[k] = ie
+		}
+	case []any:
+		for i, e := range v {
+			ie, err := interpolateJSON(e, dir)
+			if err != nil {
+				return nil, fmt.Errorf("[%d]: %w", i, err)
+			}
+			v[i] = ie
+		}
+	}
+	return v, nil
+}
@@ -19,35 +19,25 @@ const (
 	EngineLuaLaTeX Engine = "lualatex"
 )
 
-func CompileTeX(ctx context.Context, tex []byte, outPath string) error {
-	if outPath == "" {
-		return fmt.Errorf("output path cannot be empty")
-	}
-
-	outDir := filepath.Dir(outPath)
-	jobName := strings.TrimSuffix(filepath.Base(outPath), filepath.Ext(outPath))
+const jobName = "document"
 
-	if err := os.MkdirAll(outDir, 0o755); err != nil {
-		return fmt.Errorf("creating output directory: %w", err)
-	}
+// TeXFileName is the name of the rendered document inside the build
+// directory, as referenced by compiler diagnostics.
+const TeXFileName = jobName + ".tex"
 
-	workDir := outDir
-
-	srcFile, err := os.CreateTemp(workDir, jobName+"-*.tex")
+// CompileTeX compiles tex inside a private temporary build directory which is
+// removed afterwards and returns the resulting PDF.
+func CompileTeX(ctx context.Context, tex []byte) ([]byte, error) {
+	workDir, err := os.MkdirTemp("", "go-serverci-*")
 	if err != nil {
-		return fmt.Errorf("creating temp tex file: %w", err)
-	}
-	srcPath := srcFile.Name()
-	if _, err := srcFile.Write(tex); err != nil {
-		_ = srcFile.Close()
-		_ = os.Remove(srcPath)
-		return fmt.Errorf("writing tex: %w", err)
+		return nil, fmt.Errorf("creating build directory: %w", err)
 	}
-	if err := srcFile.Close(); err != nil {
-		_ = os.Remove(srcPath)
-		return fmt.Errorf("closing temp tex file: %w", err)
+	defer func() { _ = os.RemoveAll(workDir) }()
+
+	srcPath := TeXFileName
+	if err := os.WriteFile(filepath.Join(workDir, srcPath), tex, 0o600); err != nil {
+		return nil, fmt.Errorf("writing tex: %w", err)
 	}
-	defer func() { _ = os.Remove(srcPath) }()
 
 	engine := detectMagicEngine(tex)
 	if engine == "" {
@@ -55,21 +45,23 @@ func CompileTeX(ctx context.Context, tex []byte, outPath string) error {
 	}
 
 	if hasBinary("latexmk") {
-		if err := compileWithLatexmk(ctx, workDir, outDir, jobName, engine, srcPath); err != nil {
-			return err
+		if err := compileWithLatexmk(ctx, workDir, workDir, jobName, engine, srcPath); err != nil {
+			return nil, err
+		}
+	} else {
+		if !hasBinary(string(engine)) {
+			return nil, fmt.Errorf("%s not found in PATH and latexmk is unavailable", engine)
+		}
+		if err := compileRawEngine(ctx, workDir, workDir, jobName, engine, srcPath); err != nil {
+			return nil, err
 		}
-		cleanupAuxFiles(outDir, jobName)
-		return nil
 	}
 
-	if !hasBinary(string(engine)) {
-		return fmt.Errorf("%s not found in PATH and latexmk is unavailable", engine)
-	}
-	if err := compileRawEngine(ctx, workDir, outDir, jobName, engine, srcPath); err != nil {
-		return err
+	pdf, err := os.ReadFile(filepath.Join(workDir, jobName+".pdf"))
+	if err != nil {
+		return nil, fmt.Errorf("reading compiled pdf: %w", err)
 	}
-	cleanupAuxFiles(outDir, jobName)
-	return nil
+	return pdf, nil
 }
 
 func compileWithLatexmk(ctx context.Context, workDir, outDir, jobName string, engine Engine, mainTexPath string) error {
@@ -94,7 +86,7 @@ func compileWithLatexmk(ctx context.Context, workDir, outDir, jobName string, en
 
 	out, err := runCmd(ctx, workDir, "latexmk", args...)
 	if err != nil {
-		return fmt.Errorf("latexmk failed: %w\n%s", err, tail(out, 2000))
+		return newCompileError(fmt.Errorf("latexmk failed: %w", err), outDir, jobName, out)
 	}
 	return nil
 }
@@ -116,12 +108,26 @@ func compileRawEngine(ctx context.Context, workDir, outDir, jobName string, engi
 		out, err := runCmd(ctx, workDir, string(engine), args...)
 		combined += out
 		if err != nil {
-			return fmt.Errorf("%s pass %d failed: %w\n%s", engine, i+1, err, tail(combined, 2000))
+			return newCompileError(fmt.Errorf("%s pass %d failed: %w", engine, i+1, err), outDir, jobName, combined)
 		}
 	}
 	return nil
 }
 
+// newCompileError prefers the log file of the last engine pass over the
+// combined command output, which repeats itself across passes.
+func newCompileError(err error, outDir, jobName, out string) *CompileError {
+	log := out
+	if b, readErr := os.ReadFile(filepath.Join(outDir, jobName+".log")); readErr == nil {
+		log = string(b)
+	}
+	return &CompileError{
+		Err:         err,
+		Diagnostics: ParseTeXLog(log),
+		Log:         log,
+	}
+}
+
 func detectMagicEngine(tex []byte) Engine {
 	s := string(tex)
 	if len(s) > 8192 {
@@ -150,13 +156,6 @@ func hasBinary(name string) bool {
 	return err == nil
 }
 
-func cleanupAuxFiles(dir, jobName string) {
-	exts := []string{".aux", ".log", ".out", ".toc", ".synctex.gz", ".nav", ".snm", ".fls", ".fdb_latexmk", ".bbl", ".blg"}
-	for _, ext := range exts {
-		_ = os.Remove(filepath.Join(dir, jobName+ext))
-	}
-}
-
 func runCmd(ctx context.Context, wd string, bin string, args ...string) (string, error) {
 	cmd := exec.CommandContext(ctx, bin, args...)
 	if wd != "" {
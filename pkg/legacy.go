@@ -0,0 +1,69 @@
+package pkg
+
+import (
+	"reflect"
+	"strings"
+)
+
+// legacyJSONKeys maps the JSON keys used before JSON and YAML shared their
+// field names to the current ones, per type. They are still accepted when
+// decoding but deprecated.
+var legacyJSONKeys = map[string]map[string]string{
+	"CI": {
+		"authorCompany":      "author-company",
+		"authorDepartment":   "author-department",
+		"auditVersions":      "audit-versions",
+		"releaseVersions":    "release-versions",
+		"surroundingSystems": "surrounding-systems",
+	},
+	"Version": {
+		"name": "number",
+	},
+	"Description": {
+		"serviceCode": "service-code",
+		"disasterLvl": "disaster-lvl",
+	},
+}
+
+// renameLegacyJSONKeys renames legacy keys in the decoded JSON value v of
+// type t and reports whether it found any. A legacy key next to its current
+// name is left as is, so that decoding rejects it.
+func renameLegacyJSONKeys(v any, t reflect.Type) bool {
+	for t.Kind() == reflect.Pointer || t.Kind() == reflect.Slice {
+		if t.Kind() == reflect.Slice {
+			list, _ := v.([]any)
+			found := false
+			for _, e := range list {
+				found = renameLegacyJSONKeys(e, t.Elem()) || found
+			}
+			return found
+		}
+		t = t.Elem()
+	}
+	obj, ok := v.(map[string]any)
+	if !ok || t.Kind() != reflect.Struct {
+		return false
+	}
+
+	found := false
+	for old, name := range legacyJSONKeys[t.Name()] {
+		value, ok := obj[old]
+		if !ok {
+			continue
+		}
+		if _, dup := obj[name]; dup {
+			continue
+		}
+		delete(obj, old)
+		obj[name] = value
+		found = true
+	}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if e, ok := obj[name]; ok && name != "" {
+			found = renameLegacyJSONKeys(e, f.Type) || found
+		}
+	}
+	return found
+}
@@ -0,0 +1,215 @@
+package pkg
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"text/template/parse"
+)
+
+// TemplateIssue is a problem found in a template without rendering it.
+type TemplateIssue struct {
+	Line    int    `json:"line"`
+	Message string `json:"message"`
+}
+
+func (i TemplateIssue) String() string {
+	return fmt.Sprintf("%d: %s", i.Line, i.Message)
+}
+
+// LintTempl parses the template and checks every field reference against
+// the Root data model, including references inside range and with blocks
+// which a render only reaches if the data contains them. Parse errors are
+// returned as error.
+func LintTempl(texReader io.Reader) ([]TemplateIssue, error) {
+	texBytes, err := io.ReadAll(texReader)
+	if err != nil {
+		return nil, err
+	}
+	tex := string(texBytes)
+
+	tmpl, err := newTemplate(true).Parse(tex)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &linter{src: &sourceMapper{src: tex}}
+	root := reflect.TypeOf(&Root{})
+	for _, t := range tmpl.Templates() {
+		if t.Tree == nil || t.Tree.Root == nil {
+			continue
+		}
+		// Named templates may be invoked with any data, only the main
+		// template is known to receive the Root.
+		dot := root
+		if t.Name() != tmpl.Name() {
+			dot = nil
+		}
+		l.list(t.Tree.Root, dot, map[string]reflect.Type{"$": dot})
+	}
+	return l.issues, nil
+}
+
+// linter tracks the type of dot and of declared variables while walking
+// the parse tree. A nil type means the type is unknown and not checked.
+type linter struct {
+	src    *sourceMapper
+	issues []TemplateIssue
+}
+
+func (l *linter) report(n parse.Node, format string, args ...any) {
+	l.issues = append(l.issues, TemplateIssue{
+		Line:    l.src.lineOf(n.Position()),
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+func (l *linter) list(list *parse.ListNode, dot reflect.Type, vars map[string]reflect.Type) {
+	if list == nil {
+		return
+	}
+	for _, n := range list.Nodes {
+		switch n := n.(type) {
+		case *parse.ActionNode:
+			l.pipe(n.Pipe, dot, vars)
+		case *parse.IfNode:
+			l.pipe(n.Pipe, dot, vars)
+			l.list(n.List, dot, scope(vars))
+			l.list(n.ElseList, dot, scope(vars))
+		case *parse.WithNode:
+			t := l.pipe(n.Pipe, dot, vars)
+			l.list(n.List, t, scope(vars))
+			l.list(n.ElseList, dot, scope(vars))
+		case *parse.RangeNode:
+			l.rangeNode(n, dot, vars)
+		case *parse.TemplateNode:
+			if n.Pipe != nil {
+				l.pipe(n.Pipe, dot, vars)
+			}
+		}
+	}
+}
+
+func (l *linter) rangeNode(n *parse.RangeNode, dot reflect.Type, vars map[string]reflect.Type) {
+	decl := n.Pipe.Decl
+	n.Pipe.Decl = nil
+	t := l.pipe(n.Pipe, dot, vars)
+	n.Pipe.Decl = decl
+
+	var key, elem reflect.Type
+	if t = indirectType(t); t != nil {
+		switch t.Kind() {
+		case reflect.Slice, reflect.Array:
+			key, elem = reflect.TypeOf(0), t.Elem()
+		case reflect.Map:
+			key, elem = t.Key(), t.Elem()
+		case reflect.Int, reflect.Int64:
+			elem = t
+		default:
+			l.report(n, "range can't iterate over %s", t)
+		}
+	}
+
+	inner := scope(vars)
+	switch len(decl) {
+	case 1:
+		inner[decl[0].Ident[0]] = elem
+	case 2:
+		inner[decl[0].Ident[0]], inner[decl[1].Ident[0]] = key, elem
+	}
+	l.list(n.List, elem, inner)
+	l.list(n.ElseList, dot, scope(vars))
+}
+
+func scope(vars map[string]reflect.Type) map[string]reflect.Type {
+	inner := make(map[string]reflect.Type, len(vars))
+	for k, v := range vars {
+		inner[k] = v
+	}
+	return inner
+}
+
+// pipe checks a pipeline and returns the type it evaluates to.
+func (l *linter) pipe(p *parse.PipeNode, dot reflect.Type, vars map[string]reflect.Type) reflect.Type {
+	if p == nil {
+		return nil
+	}
+	var t reflect.Type
+	for _, cmd := range p.Cmds {
+		t = l.command(cmd, dot, vars)
+	}
+	for _, v := range p.Decl {
+		vars[v.Ident[0]] = t
+	}
+	return t
+}
+
+func (l *linter) command(cmd *parse.CommandNode, dot reflect.Type, vars map[string]reflect.Type) reflect.Type {
+	for _, arg := range cmd.Args[1:] {
+		l.arg(arg, dot, vars)
+	}
+	return l.arg(cmd.Args[0], dot, vars)
+}
+
+func (l *linter) arg(n parse.Node, dot reflect.Type, vars map[string]reflect.Type) reflect.Type {
+	switch n := n.(type) {
+	case *parse.DotNode:
+		return dot
+	case *parse.FieldNode:
+		return l.fields(n, dot, n.Ident)
+	case *parse.VariableNode:
+		t, ok := vars[n.Ident[0]]
+		if !ok {
+			l.report(n, "undefined variable %s", n.Ident[0])
+			return nil
+		}
+		return l.fields(n, t, n.Ident[1:])
+	case *parse.ChainNode:
+		return l.fields(n, l.arg(n.Node, dot, vars), n.Field)
+	case *parse.PipeNode:
+		return l.pipe(n, dot, vars)
+	}
+	// Function results, literals and nil are not checked.
+	return nil
+}
+
+// fields resolves a chain of field names starting at t.
+func (l *linter) fields(n parse.Node, t reflect.Type, names []string) reflect.Type {
+	for _, name := range names {
+		t = indirectType(t)
+		if t == nil {
+			return nil
+		}
+		switch t.Kind() {
+		case reflect.Struct:
+			if f, ok := t.FieldByName(name); ok && f.IsExported() {
+				t = f.Type
+				continue
+			}
+			if m, ok := reflect.PointerTo(t).MethodByName(name); ok && m.Type.NumOut() > 0 {
+				t = m.Type.Out(0)
+				continue
+			}
+			l.report(n, "can't evaluate field %s in type %s", name, t)
+			return nil
+		case reflect.Map:
+			t = t.Elem()
+		case reflect.Interface:
+			return nil
+		default:
+			l.report(n, "can't evaluate field %s in type %s", name, t)
+			return nil
+		}
+	}
+	return t
+}
+
+func indirectType(t reflect.Type) reflect.Type {
+	for t != nil && t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t != nil && t.Kind() == reflect.Interface {
+		return nil
+	}
+	return t
+}
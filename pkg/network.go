@@ -0,0 +1,123 @@
+package pkg
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// interfaceAddr is an address of an interface, from ip and subnet or from
+// addresses. network is nil if the ip has no valid subnet.
+type interfaceAddr struct {
+	path       string
+	subnetPath string
+	ip         net.IP
+	network    *net.IPNet
+}
+
+func (a interfaceAddr) isIPv4() bool { return a.ip.To4() != nil }
+
+// interfaceAddrs returns the valid addresses of an interface.
+func interfaceAddrs(path string, in *Interface) []interfaceAddr {
+	var addrs []interfaceAddr
+	if ip := net.ParseIP(strOrEmpty(in.IP)); ip != nil {
+		network, _ := ipNetwork(ip, strOrEmpty(in.Subnet))
+		addrs = append(addrs, interfaceAddr{path: path + ".ip", subnetPath: path + ".subnet", ip: ip, network: network})
+	}
+	for i, a := range in.Addresses {
+		ip, network, err := net.ParseCIDR(strOrEmpty(a))
+		if err != nil {
+			continue
+		}
+		p := fmt.Sprintf("%s.addresses[%d]", path, i)
+		addrs = append(addrs, interfaceAddr{path: p, subnetPath: p, ip: ip, network: network})
+	}
+	return addrs
+}
+
+// ipNetwork returns the network of ip with a subnet given as "/N" or, for
+// IPv4, as dotted mask.
+func ipNetwork(ip net.IP, subnet string) (*net.IPNet, bool) {
+	bits := net.IPv6len * 8
+	if v4 := ip.To4(); v4 != nil {
+		ip, bits = v4, net.IPv4len*8
+	}
+	ones, ok := prefixLen(subnet, bits)
+	if !ok {
+		return nil, false
+	}
+	mask := net.CIDRMask(ones, bits)
+	return &net.IPNet{IP: ip.Mask(mask), Mask: mask}, true
+}
+
+func prefixLen(subnet string, bits int) (int, bool) {
+	if strings.HasPrefix(subnet, "/") {
+		n, err := strconv.Atoi(subnet[1:])
+		return n, err == nil && n >= 0 && n <= bits
+	}
+	if bits != net.IPv4len*8 {
+		return 0, false
+	}
+	mask := net.ParseIP(subnet).To4()
+	if mask == nil {
+		return 0, false
+	}
+	ones, maskBits := net.IPMask(mask).Size()
+	return ones, maskBits != 0
+}
+
+func broadcastAddress(n *net.IPNet) net.IP {
+	b := make(net.IP, len(n.IP))
+	for i := range n.IP {
+		b[i] = n.IP[i] | ^n.Mask[i]
+	}
+	return b
+}
+
+func networksOverlap(a, b *net.IPNet) bool {
+	return a.Contains(b.IP) || b.Contains(a.IP)
+}
+
+// filterAddresses returns the addresses of the given family, 4 or 6, for
+// templates. Invalid entries are skipped.
+func filterAddresses(family int, list []*string) []string {
+	var out []string
+	for _, p := range list {
+		s := strOrEmpty(p)
+		ip, _, err := net.ParseCIDR(s)
+		if err != nil {
+			continue
+		}
+		if (ip.To4() != nil) == (family == 4) {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// addressIP returns the address of CIDR notation without prefix length.
+func addressIP(cidr string) string {
+	ip, _, _ := strings.Cut(cidr, "/")
+	return ip
+}
+
+// addressPrefix returns the prefix length of CIDR notation.
+func addressPrefix(cidr string) string {
+	_, prefix, _ := strings.Cut(cidr, "/")
+	return prefix
+}
+
+// addressNetmask returns the dotted netmask of an IPv4 address in CIDR
+// notation, or "/N" for IPv6.
+func addressNetmask(cidr string) string {
+	ip, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return ""
+	}
+	if ip.To4() == nil {
+		ones, _ := network.Mask.Size()
+		return "/" + strconv.Itoa(ones)
+	}
+	return net.IP(network.Mask).String()
+}
@@ -0,0 +1,173 @@
+package pkg
+
+import (
+	"sort"
+	"strings"
+	"testing"
+)
+
+// errorCodes decodes the YAML document doc and returns its errors as
+// "path CODE", sorted.
+func errorCodes(t *testing.T, doc string) []string {
+	t.Helper()
+	root, err := DecodeYaml(strings.NewReader(doc))
+	if err != nil {
+		t.Fatalf("decoding %q: %v", doc, err)
+	}
+	var codes []string
+	for _, f := range root.Check().AtLeast(SeverityError) {
+		codes = append(codes, f.Path+" "+f.Code)
+	}
+	sort.Strings(codes)
+	return codes
+}
+
+// validationTest is a CI document and the errors it is expected to have.
+type validationTest struct {
+	name string
+	doc  string
+	want []string
+}
+
+func runValidationTests(t *testing.T, tests []validationTest) {
+	t.Helper()
+	for _, tt := range tests {
+		want := append([]string(nil), tt.want...)
+		sort.Strings(want)
+		got := strings.Join(errorCodes(t, tt.doc), "\n")
+		if got != strings.Join(want, "\n") {
+			t.Errorf("%s: got errors\n%s\nwant\n%s", tt.name, got, strings.Join(want, "\n"))
+		}
+	}
+}
+
+func TestValidateInterfaces(t *testing.T) {
+	runValidationTests(t, []validationTest{
+		{
+			name: "static",
+			doc:  "ci:\n  interfaces:\n  - {name: a, dhcp: false, ip: 10.0.0.5, subnet: /24, gateway: 10.0.0.1}\n",
+		},
+		{
+			name: "dotted netmask",
+			doc:  "ci:\n  interfaces:\n  - {name: a, dhcp: false, ip: 10.0.0.5, subnet: 255.255.255.0, gateway: 10.0.0.1}\n",
+		},
+		{
+			name: "static without ip and subnet",
+			doc:  "ci:\n  interfaces:\n  - {name: a, dhcp: false}\n",
+			want: []string{"ci.interfaces[0].ip E_STATIC_IP_MISSING", "ci.interfaces[0].subnet E_STATIC_SUBNET_MISSING"},
+		},
+		{
+			name: "dhcp with ip and subnet",
+			doc:  "ci:\n  interfaces:\n  - {name: a, dhcp: true, ip: 10.0.0.5, subnet: /24}\n",
+			want: []string{"ci.interfaces[0].ip E_DHCP_IP_SET", "ci.interfaces[0].subnet E_DHCP_SUBNET_SET"},
+		},
+		{
+			name: "network address",
+			doc:  "ci:\n  interfaces:\n  - {name: a, ip: 10.0.0.0, subnet: /24}\n",
+			want: []string{"ci.interfaces[0].ip E_IP_NETWORK_ADDRESS"},
+		},
+		{
+			name: "broadcast address",
+			doc:  "ci:\n  interfaces:\n  - {name: a, ip: 10.0.0.255, subnet: 255.255.255.0}\n",
+			want: []string{"ci.interfaces[0].ip E_IP_BROADCAST_ADDRESS"},
+		},
+		{
+			name: "point-to-point",
+			doc:  "ci:\n  interfaces:\n  - {name: a, ip: 10.0.0.0, subnet: /31}\n  - {name: b, ip: 10.0.1.1, subnet: /32}\n",
+		},
+		{
+			name: "gateway outside subnet",
+			doc:  "ci:\n  interfaces:\n  - {name: a, ip: 10.0.0.5, subnet: /24, gateway: 10.0.1.1}\n",
+			want: []string{"ci.interfaces[0].gateway E_GATEWAY_OUTSIDE_SUBNET"},
+		},
+		{
+			name: "duplicate ip",
+			doc:  "ci:\n  interfaces:\n  - {name: a, vlan: 10, ip: 10.0.0.5, subnet: /24}\n  - {name: b, vlan: 10, ip: 10.0.0.5, subnet: /24}\n",
+			want: []string{"ci.interfaces[1].ip E_IP_DUPLICATE"},
+		},
+		{
+			name: "overlapping subnets on different VLANs",
+			doc:  "ci:\n  interfaces:\n  - {name: a, vlan: 10, ip: 10.0.0.5, subnet: /16}\n  - {name: b, vlan: 20, ip: 10.0.1.5, subnet: /24}\n",
+			want: []string{"ci.interfaces[1].subnet E_SUBNET_OVERLAP"},
+		},
+		{
+			name: "overlapping subnets on the same VLAN",
+			doc:  "ci:\n  interfaces:\n  - {name: a, vlan: 10, ip: 10.0.0.5, subnet: /16}\n  - {name: b, vlan: 10, ip: 10.0.1.5, subnet: /24}\n",
+		},
+		{
+			name: "subnet prefix out of range",
+			doc:  "ci:\n  interfaces:\n  - {name: a, ip: 10.0.0.5, subnet: /33}\n",
+			want: []string{"ci.interfaces[0].subnet E_SUBNET_PREFIX_RANGE"},
+		},
+		{
+			name: "non-contiguous netmask",
+			doc:  "ci:\n  interfaces:\n  - {name: a, ip: 10.0.0.5, subnet: 255.0.255.0}\n",
+			want: []string{"ci.interfaces[0].subnet E_SUBNET_NOT_CONTIGUOUS"},
+		},
+	})
+}
+
+func TestValidateInterfacesIPv6(t *testing.T) {
+	runValidationTests(t, []validationTest{
+		{
+			name: "dual stack",
+			doc:  "ci:\n  interfaces:\n  - {name: a, dhcp: false, ip: 10.0.0.5, subnet: /24, addresses: [2001:db8::5/64], ipv6-mode: static, gateway6: 2001:db8::1}\n",
+		},
+		{
+			name: "static IPv6 only",
+			doc:  "ci:\n  interfaces:\n  - {name: a, dhcp: false, addresses: [2001:db8::5/64], ipv6-mode: static}\n",
+		},
+		{
+			name: "static IPv4 from addresses",
+			doc:  "ci:\n  interfaces:\n  - {name: a, dhcp: false, addresses: [10.0.0.5/24]}\n",
+		},
+		{
+			name: "ip without subnet next to addresses",
+			doc:  "ci:\n  interfaces:\n  - {name: a, dhcp: false, ip: 10.0.0.5, addresses: [2001:db8::5/64]}\n",
+			want: []string{"ci.interfaces[0].subnet E_STATIC_SUBNET_MISSING"},
+		},
+		{
+			name: "dhcp with IPv6 address",
+			doc:  "ci:\n  interfaces:\n  - {name: a, dhcp: true, addresses: [2001:db8::5/64], ipv6-mode: static}\n",
+		},
+		{
+			name: "dhcp with IPv4 address",
+			doc:  "ci:\n  interfaces:\n  - {name: a, dhcp: true, addresses: [10.0.0.5/24]}\n",
+			want: []string{"ci.interfaces[0].addresses[0] E_DHCP_IP_SET"},
+		},
+		{
+			name: "static mode without address",
+			doc:  "ci:\n  interfaces:\n  - {name: a, dhcp: true, ipv6-mode: static}\n",
+			want: []string{"ci.interfaces[0].addresses E_IPV6_STATIC_MISSING"},
+		},
+		{
+			name: "slaac with global address",
+			doc:  "ci:\n  interfaces:\n  - {name: a, dhcp: true, addresses: [2001:db8::5/64, fe80::5/64], ipv6-mode: slaac}\n",
+			want: []string{"ci.interfaces[0].addresses[0] E_IPV6_ADDRESS_SET"},
+		},
+		{
+			name: "invalid mode and address",
+			doc:  "ci:\n  interfaces:\n  - {name: a, dhcp: true, addresses: [2001:db8::5], ipv6-mode: auto}\n",
+			want: []string{"ci.interfaces[0].addresses[0] E_ADDRESS_INVALID", "ci.interfaces[0].ipv6_mode E_IPV6_MODE_INVALID"},
+		},
+		{
+			name: "gateway families",
+			doc:  "ci:\n  interfaces:\n  - {name: a, dhcp: false, ip: 10.0.0.5, subnet: /24, gateway: 2001:db8::1, addresses: [2001:db8::5/64], gateway6: 10.0.0.1}\n",
+			want: []string{"ci.interfaces[0].gateway E_GATEWAY_FAMILY", "ci.interfaces[0].gateway6 E_GATEWAY_FAMILY"},
+		},
+		{
+			name: "gateway6 outside subnet",
+			doc:  "ci:\n  interfaces:\n  - {name: a, dhcp: false, addresses: [2001:db8::5/64], gateway6: 2001:db9::1}\n",
+			want: []string{"ci.interfaces[0].gateway6 E_GATEWAY_OUTSIDE_SUBNET"},
+		},
+		{
+			name: "link-local gateway6",
+			doc:  "ci:\n  interfaces:\n  - {name: a, dhcp: false, addresses: [2001:db8::5/64], gateway6: fe80::1}\n",
+		},
+		{
+			name: "duplicate IPv6 address",
+			doc:  "ci:\n  interfaces:\n  - {name: a, vlan: 10, dhcp: false, addresses: [2001:db8::5/64]}\n  - {name: b, vlan: 10, dhcp: false, addresses: [2001:db8::5/64]}\n",
+			want: []string{"ci.interfaces[1].addresses[0] E_IP_DUPLICATE"},
+		},
+	})
+}
@@ -0,0 +1,162 @@
+package pkg
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+)
+
+// Policy is a set of rules evaluated in addition to the built-in
+// validation, e.g. department specific requirements.
+type Policy struct {
+	Rules []*PolicyRule `yaml:"rules"`
+}
+
+// PolicyRule reports Message at the path of the checked section if
+// Require is false. Rules with ForEach are checked for every entry of
+// that list, rules with When only where it is true.
+type PolicyRule struct {
+	Code     string   `yaml:"code"`
+	Severity Severity `yaml:"severity"`
+	Message  string   `yaml:"message"`
+	ForEach  string   `yaml:"foreach"`
+	Field    string   `yaml:"field"`
+	When     string   `yaml:"when"`
+	Require  string   `yaml:"require"`
+
+	when, require expr
+}
+
+// LoadPolicy decodes a policy file and compiles its expressions.
+func LoadPolicy(r io.Reader) (*Policy, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var p Policy
+	if err := decodeYamlStrict(b, &p); err != nil {
+		return nil, err
+	}
+
+	for i, rule := range p.Rules {
+		if rule == nil {
+			return nil, fmt.Errorf("rules[%d]: empty rule", i)
+		}
+		if err := rule.compile(); err != nil {
+			return nil, fmt.Errorf("rules[%d] (%s): %w", i, rule.Code, err)
+		}
+	}
+	return &p, nil
+}
+
+func (r *PolicyRule) compile() error {
+	switch {
+	case r.Code == "":
+		return errors.New("missing code")
+	case r.Message == "":
+		return errors.New("missing message")
+	case r.Require == "":
+		return errors.New("missing require")
+	}
+	switch r.Severity {
+	case "":
+		r.Severity = SeverityError
+	case SeverityError, SeverityWarning, SeverityInfo:
+	default:
+		return fmt.Errorf("invalid severity %q (use error, warning or info)", r.Severity)
+	}
+
+	var err error
+	if r.When != "" {
+		if r.when, err = parseExpr(r.When); err != nil {
+			return fmt.Errorf("when: %w", err)
+		}
+	}
+	if r.require, err = parseExpr(r.Require); err != nil {
+		return fmt.Errorf("require: %w", err)
+	}
+	return nil
+}
+
+// Check evaluates every rule against the CI. Rules which fail to evaluate,
+// e.g. comparing a string with a number, are reported as errors.
+func (p *Policy) Check(c *CI) MultiError {
+	var me MultiError
+	if p == nil || c == nil {
+		return me
+	}
+	ci, _ := toGeneric(reflect.ValueOf(c)).(map[string]any)
+	for _, r := range p.Rules {
+		if r.ForEach == "" {
+			r.check(&me, "ci", &exprScope{item: ci, ci: ci})
+			continue
+		}
+		items, _ := (&exprScope{item: ci}).lookup(strings.Split(r.ForEach, ".")).([]any)
+		for i, item := range items {
+			if m, ok := item.(map[string]any); ok {
+				path := fmt.Sprintf("ci.%s[%d]", pathName(r.ForEach), i)
+				r.check(&me, path, &exprScope{item: m, ci: ci})
+			}
+		}
+	}
+	return me
+}
+
+func (r *PolicyRule) check(me *MultiError, path string, s *exprScope) {
+	if r.Field != "" {
+		path += "." + pathName(r.Field)
+	}
+	if r.when != nil {
+		v, err := r.when.eval(s)
+		if err != nil {
+			me.add(path, errorf(CodePolicyEval, "policy rule %s: when: %v", r.Code, err))
+			return
+		}
+		if !truthy(v) {
+			return
+		}
+	}
+	v, err := r.require.eval(s)
+	if err != nil {
+		me.add(path, errorf(CodePolicyEval, "policy rule %s: require: %v", r.Code, err))
+		return
+	}
+	if !truthy(v) {
+		me.addSeverity(path, r.Severity, errorf(r.Code, "%s", r.Message))
+	}
+}
+
+// pathName converts a YAML path to the form used in validation paths.
+func pathName(yamlPath string) string {
+	return strings.ReplaceAll(yamlPath, "-", "_")
+}
+
+// toGeneric converts v into maps keyed by YAML names, lists and scalars,
+// the form policy expressions are evaluated on.
+func toGeneric(v reflect.Value) any {
+	for v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Struct:
+		m := make(map[string]any, v.NumField())
+		for i := 0; i < v.NumField(); i++ {
+			if name := yamlFieldName(v.Type().Field(i)); name != "" {
+				m[name] = toGeneric(v.Field(i))
+			}
+		}
+		return m
+	case reflect.Slice:
+		list := make([]any, 0, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			list = append(list, toGeneric(v.Index(i)))
+		}
+		return list
+	}
+	return v.Interface()
+}
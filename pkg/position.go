@@ -0,0 +1,67 @@
+package pkg
+
+import (
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Locate sets the file of every finding and, if root was decoded from YAML,
+// the line and column of the field it is about. Findings about a missing
+// field point at the closest enclosing field present in the document, and
+// fields from an extended document at that document.
+func (r *Root) Locate(file string, m MultiError) {
+	for i := range m {
+		m[i].File = file
+		if r == nil || r.source == nil || m[i].Path == "" {
+			continue
+		}
+		if n := lookupNode(r.source, m[i].Path); n != nil {
+			m[i].Line, m[i].Column = n.Line, n.Column
+			if f := r.files[n]; f != "" {
+				m[i].File = f
+			}
+		}
+	}
+}
+
+// lookupNode returns the node of a validation path like
+// "ci.interfaces[1].ip", or of its longest prefix present in the document.
+// Fields are reported at their key, list entries at the entry.
+func lookupNode(n *yaml.Node, path string) *yaml.Node {
+	at := n
+	for _, part := range strings.Split(path, ".") {
+		name, indexes, _ := strings.Cut(part, "[")
+		key, value := mappingEntry(n, name)
+		if key == nil {
+			return at
+		}
+		at, n = key, value
+		if indexes == "" {
+			continue
+		}
+		for _, index := range strings.Split(strings.TrimSuffix(indexes, "]"), "][") {
+			i, err := strconv.Atoi(index)
+			if err != nil || n.Kind != yaml.SequenceNode || i < 0 || i >= len(n.Content) {
+				return at
+			}
+			at, n = n.Content[i], n.Content[i]
+		}
+	}
+	return at
+}
+
+// mappingEntry returns the key and value of key in a mapping node,
+// comparing keys in the form of validation paths.
+func mappingEntry(n *yaml.Node, key string) (*yaml.Node, *yaml.Node) {
+	if n.Kind != yaml.MappingNode {
+		return nil, nil
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if pathName(n.Content[i].Value) == key {
+			return n.Content[i], n.Content[i+1]
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,190 @@
+package pkg
+
+import (
+	"reflect"
+)
+
+const schemaDialect = "https://json-schema.org/draft/2020-12/schema"
+
+// Schema is a JSON Schema (draft 2020-12) node.
+type Schema struct {
+	Schema               string             `json:"$schema,omitempty"`
+	Ref                  string             `json:"$ref,omitempty"`
+	Title                string             `json:"title,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Type                 any                `json:"type,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *bool              `json:"additionalProperties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	AnyOf                []*Schema          `json:"anyOf,omitempty"`
+	Enum                 []any              `json:"enum,omitempty"`
+	Pattern              string             `json:"pattern,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Minimum              *int               `json:"minimum,omitempty"`
+	Maximum              *int               `json:"maximum,omitempty"`
+	Defs                 map[string]*Schema `json:"$defs,omitempty"`
+}
+
+// fieldSchemas add the constraints of the validators to the schema of a
+// field, keyed by "Type.Field". For lists they apply to the items.
+var fieldSchemas = map[string]func(s *Schema){
+	"Version.Number":        versionNumberSchema,
+	"Version.Date":          dateSchema,
+	"AuditVersion.Number":   versionNumberSchema,
+	"AuditVersion.Date":     dateSchema,
+	"ReleaseVersion.Number": versionNumberSchema,
+	"ReleaseVersion.Date":   dateSchema,
+	"Description.DisasterLvl": func(s *Schema) {
+		s.Minimum = ptr(0)
+	},
+	"Configuration.FQDN": func(s *Schema) {
+		s.Format = "hostname"
+	},
+	"Configuration.RAM": func(s *Schema) {
+		s.Minimum, s.Description = ptr(0), "RAM in GB."
+	},
+	"Configuration.CPU": func(s *Schema) {
+		s.Minimum = ptr(0)
+	},
+	"Configuration.NTP": ipSchema,
+	"Interface.VLAN": func(s *Schema) {
+		s.Minimum, s.Maximum = ptr(minVLAN), ptr(maxVLAN)
+	},
+	"Interface.IP": ipSchema,
+	"Interface.Subnet": func(s *Schema) {
+		s.Pattern = `^(/\d{1,3}|\d{1,3}(\.\d{1,3}){3})$`
+		s.Description = "Prefix length like /24, or IPv4 netmask like 255.255.255.0."
+	},
+	"Interface.Gateway": ipSchema,
+	"Interface.Addresses": func(s *Schema) {
+		s.Pattern = `^[0-9A-Fa-f:.]+/\d{1,3}$`
+		s.Description = "IPv4 or IPv6 address in CIDR notation, e.g. 10.0.0.5/24 or 2001:db8::5/64."
+	},
+	"Interface.IPv6Mode": func(s *Schema) {
+		s.Enum = []any{IPv6ModeStatic, IPv6ModeSLAAC, IPv6ModeDHCPv6, nil}
+	},
+	"Interface.Gateway6": ipSchema,
+	"Interface.DNS":      ipSchema,
+	"Volume.Size": func(s *Schema) {
+		s.Minimum, s.Description = ptr(1), "Size in GB."
+	},
+	"Service.Port": func(s *Schema) {
+		s.Minimum, s.Maximum = ptr(minPort), ptr(maxPort)
+	},
+	"Service.Protocol": func(s *Schema) {
+		s.Enum = []any{ProtocolTCP, ProtocolUDP, ProtocolSCTP, nil}
+		s.Description = "Defaults to tcp."
+	},
+	"Volume.Mount": func(s *Schema) {
+		s.Pattern = mountPattern
+		s.Description = "Mount point like /var/lib/pgsql, or drive letter like D:."
+	},
+}
+
+func versionNumberSchema(s *Schema) {
+	// Unquoted numbers like 1.0 are read as version numbers too.
+	s.Type = []string{"string", "number", "null"}
+	s.Pattern = reVersionNumber.String()
+}
+
+func dateSchema(s *Schema) {
+	s.Pattern = datePattern
+	s.Description = "Date as DD.MM.YYYY."
+}
+
+func ipSchema(s *Schema) {
+	s.Description = "IPv4 or IPv6 address."
+	s.AnyOf = []*Schema{{Format: "ipv4"}, {Format: "ipv6"}, {Type: "null"}}
+}
+
+func ptr[T any](v T) *T { return &v }
+
+// CISchema returns the JSON Schema of the CI document, derived from the
+// Root type with the field names used in YAML documents.
+func CISchema() *Schema {
+	g := &schemaGenerator{defs: map[string]*Schema{}}
+	s := g.object(reflect.TypeOf(Root{}))
+	s.Schema = schemaDialect
+	s.Title = "CI document"
+	s.Required = []string{"ci"}
+	// extends is read by DecodeYamlFile and not part of Root.
+	s.Properties["extends"] = &Schema{
+		Type:        "array",
+		Items:       &Schema{Type: "string"},
+		Description: "Documents this one extends, relative to its directory.",
+	}
+	s.Defs = g.defs
+	return s
+}
+
+type schemaGenerator struct {
+	defs map[string]*Schema
+}
+
+func (g *schemaGenerator) object(t reflect.Type) *Schema {
+	s := &Schema{
+		Type:                 "object",
+		Properties:           map[string]*Schema{},
+		AdditionalProperties: ptr(false),
+	}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := yamlFieldName(f)
+		if name == "" {
+			continue
+		}
+		fs := g.field(f.Type)
+		if rule, ok := fieldSchemas[t.Name()+"."+f.Name]; ok {
+			if fs.Items != nil {
+				rule(fs.Items)
+			} else {
+				rule(fs)
+			}
+		}
+		s.Properties[name] = fs
+	}
+	return s
+}
+
+// field returns the schema of a field type. Pointer fields may be null as
+// YAML documents often leave keys empty.
+func (g *schemaGenerator) field(t reflect.Type) *Schema {
+	nullable := t.Kind() == reflect.Pointer || t.Kind() == reflect.Slice
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	var s *Schema
+	switch t.Kind() {
+	case reflect.Struct:
+		if _, ok := g.defs[t.Name()]; !ok {
+			g.defs[t.Name()] = nil
+			g.defs[t.Name()] = g.object(t)
+		}
+		ref := &Schema{Ref: "#/$defs/" + t.Name()}
+		if nullable {
+			return &Schema{AnyOf: []*Schema{ref, {Type: "null"}}}
+		}
+		return ref
+	case reflect.Slice:
+		item := g.field(t.Elem())
+		if item.AnyOf != nil && item.AnyOf[0].Ref != "" {
+			item = item.AnyOf[0]
+		}
+		s = &Schema{Type: "array", Items: item}
+	case reflect.String:
+		s = &Schema{Type: "string"}
+	case reflect.Bool:
+		s = &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int64:
+		s = &Schema{Type: "integer"}
+	default:
+		s = &Schema{}
+	}
+	// A schema without type already allows null.
+	if typ, ok := s.Type.(string); ok && nullable {
+		s.Type = []string{typ, "null"}
+	}
+	return s
+}
@@ -0,0 +1,112 @@
+package pkg
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Redacted replaces the values of sensitive fields.
+const Redacted = "[redacted]"
+
+// isSensitive reports whether a field holds a secret, like an SNMP
+// community, which is marked by the tag sensitive:"true".
+func isSensitive(f reflect.StructField) bool {
+	return f.Tag.Get("sensitive") == "true"
+}
+
+// sensitiveFields calls fn with every set sensitive field of v.
+func sensitiveFields(v reflect.Value, fn func(f reflect.Value)) {
+	for v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return
+		}
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			f := v.Type().Field(i)
+			if !f.IsExported() {
+				continue
+			}
+			if isSensitive(f) {
+				if !v.Field(i).IsNil() {
+					fn(v.Field(i))
+				}
+				continue
+			}
+			sensitiveFields(v.Field(i), fn)
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			sensitiveFields(v.Index(i), fn)
+		}
+	}
+}
+
+// Secrets are the values of sensitive fields, which are kept out of logs
+// and messages.
+type Secrets []string
+
+// Secrets returns the non-empty values of the sensitive fields of r.
+func (r *Root) Secrets() Secrets {
+	var s Secrets
+	sensitiveFields(reflect.ValueOf(r), func(f reflect.Value) {
+		if v := f.Elem().String(); v != "" {
+			s = append(s, v)
+			if e := EscapeTeX(v); e != v {
+				s = append(s, e)
+			}
+		}
+	})
+	// Replace longer secrets first, in case one contains another.
+	sort.Slice(s, func(i, j int) bool { return len(s[i]) > len(s[j]) })
+	return s
+}
+
+// Redact replaces the secrets in text, also in their LaTeX escaped form.
+func (s Secrets) Redact(text string) string {
+	for _, v := range s {
+		text = strings.ReplaceAll(text, v, Redacted)
+	}
+	return text
+}
+
+// RedactFindings replaces the secrets in the messages of m.
+func (s Secrets) RedactFindings(m MultiError) {
+	for i := range m {
+		msg := m[i].Err.Error()
+		if r := s.Redact(msg); r != msg {
+			m[i].Err = errors.New(r)
+		}
+	}
+}
+
+// RedactDiagnostics replaces the secrets in the messages and quoted source
+// of diags, which may contain lines of the rendered document.
+func (s Secrets) RedactDiagnostics(diags []Diagnostic) {
+	for i := range diags {
+		diags[i].Message = s.Redact(diags[i].Message)
+		diags[i].Context = s.Redact(diags[i].Context)
+	}
+}
+
+// Redacted returns a copy of r with the values of sensitive fields
+// replaced, e.g. to write the rendered document for inspection.
+func (r *Root) Redacted() (*Root, error) {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return nil, err
+	}
+	var c Root
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, err
+	}
+	sensitiveFields(reflect.ValueOf(&c), func(f reflect.Value) {
+		f.Set(reflect.ValueOf(ptr(Redacted)))
+	})
+	return &c, nil
+}
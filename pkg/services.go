@@ -0,0 +1,130 @@
+package pkg
+
+import (
+	"fmt"
+	"net"
+)
+
+const (
+	minPort = 1
+	maxPort = 65535
+)
+
+func validatePort(_ string, p *int) error {
+	if p == nil {
+		return nil
+	}
+	if *p < minPort || *p > maxPort {
+		return errorf(CodePortRange, "port must be in [%d..%d], got %d", minPort, maxPort, *p)
+	}
+	return nil
+}
+
+func validateProtocol(_ string, p *string) error {
+	switch s := strOrEmpty(p); s {
+	case "", ProtocolTCP, ProtocolUDP, ProtocolSCTP:
+		return nil
+	default:
+		return errorf(CodeProtocolInvalid, "invalid protocol %q (expected %s, %s or %s)", s, ProtocolTCP, ProtocolUDP, ProtocolSCTP)
+	}
+}
+
+// isWildcardListen reports whether a service listening on s listens on all
+// interfaces.
+func isWildcardListen(s string) bool {
+	switch s {
+	case "", "*", "any":
+		return true
+	}
+	ip := net.ParseIP(s)
+	return ip != nil && ip.IsUnspecified()
+}
+
+// validateServiceSet checks that every service listens on an interface or
+// address of the server, and that no two services bind the same port and
+// protocol on the same address. A service listening on an interface binds
+// all its addresses. Services without protocol use TCP.
+func validateServiceSet(path string, services []*Service, interfaces []*Interface) error {
+	type binding struct {
+		path     string
+		port     int
+		protocol string
+		// addrs are the addresses bound, nil for all interfaces.
+		addrs []string
+	}
+	ifAddrs := map[string][]string{}
+	ownIPs := map[string]bool{}
+	for i, in := range interfaces {
+		if in == nil {
+			continue
+		}
+		name := strOrEmpty(in.Name)
+		// An interface without known addresses, e.g. with DHCP, is bound
+		// by name only.
+		addrs := []string{"interface " + name}
+		for _, a := range interfaceAddrs(fmt.Sprintf("ci.interfaces[%d]", i), in) {
+			addrs = append(addrs, a.ip.String())
+			ownIPs[a.ip.String()] = true
+		}
+		if name != "" {
+			ifAddrs[name] = addrs
+		}
+	}
+
+	var me MultiError
+	var bindings []binding
+	for i, svc := range services {
+		if svc == nil {
+			continue
+		}
+		p := fmt.Sprintf("%s[%d]", path, i)
+		listen := strOrEmpty(svc.Interface)
+		var addrs []string
+		switch ip := net.ParseIP(listen); {
+		case isWildcardListen(listen):
+		case ip != nil:
+			if !ownIPs[ip.String()] && !ip.IsLoopback() {
+				me.add(p+".interface", errorf(CodeServiceInterfaceUnknown, "%s is not an address of any interface", listen))
+				continue
+			}
+			addrs = []string{ip.String()}
+		case ifAddrs[listen] != nil:
+			addrs = ifAddrs[listen]
+		default:
+			me.add(p+".interface", errorf(CodeServiceInterfaceUnknown, "unknown interface %q (expected the name of an interface or one of its addresses)", listen))
+			continue
+		}
+		if svc.Port == nil || validatePort("", svc.Port) != nil || validateProtocol("", svc.Protocol) != nil {
+			continue
+		}
+
+		b := binding{path: p, port: *svc.Port, protocol: strOrEmpty(svc.Protocol), addrs: addrs}
+		if b.protocol == "" {
+			b.protocol = ProtocolTCP
+		}
+		for _, other := range bindings {
+			if other.port == b.port && other.protocol == b.protocol && bindingsOverlap(other.addrs, b.addrs) {
+				me.add(p+".port", errorf(CodePortDuplicate, "%s port %d is also bound by %s", b.protocol, b.port, other.path))
+				break
+			}
+		}
+		bindings = append(bindings, b)
+	}
+	return me.ToError()
+}
+
+// bindingsOverlap reports whether two sets of bound addresses share one,
+// where nil stands for all addresses.
+func bindingsOverlap(a, b []string) bool {
+	if a == nil || b == nil {
+		return true
+	}
+	for _, x := range a {
+		for _, y := range b {
+			if x == y {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,65 @@
+package pkg
+
+import "testing"
+
+func TestValidateServices(t *testing.T) {
+	const interfaces = "ci:\n  interfaces:\n" +
+		"  - {name: eth0, dhcp: false, ip: 10.0.0.5, subnet: /24, addresses: ['10.0.1.5/24', '2001:db8::5/64']}\n" +
+		"  - {name: eth1, dhcp: true}\n" +
+		"  services:\n"
+	runValidationTests(t, []validationTest{
+		{
+			name: "valid",
+			doc: interfaces +
+				"  - {name: ssh, port: 22}\n" +
+				"  - {name: dns, port: 53, protocol: udp}\n" +
+				"  - {name: dns-tcp, port: 53, protocol: tcp}\n" +
+				"  - {name: web, port: 80, interface: eth0}\n" +
+				"  - {name: web-dhcp, port: 80, interface: eth1}\n" +
+				"  - {name: admin, port: 8080, interface: 127.0.0.1}\n",
+		},
+		{
+			name: "port and protocol",
+			doc:  interfaces + "  - {port: 0}\n  - {port: 65536}\n  - {port: 1, protocol: icmp}\n",
+			want: []string{"ci.services[0].port E_PORT_RANGE", "ci.services[1].port E_PORT_RANGE", "ci.services[2].protocol E_PROTOCOL_INVALID"},
+		},
+		{
+			name: "duplicate on all interfaces",
+			doc:  interfaces + "  - {port: 22}\n  - {port: 22, protocol: tcp}\n",
+			want: []string{"ci.services[1].port E_PORT_DUPLICATE"},
+		},
+		{
+			name: "all interfaces and one",
+			doc:  interfaces + "  - {port: 22, interface: '*'}\n  - {port: 22, interface: eth1}\n",
+			want: []string{"ci.services[1].port E_PORT_DUPLICATE"},
+		},
+		{
+			name: "interface and its address",
+			doc:  interfaces + "  - {port: 80, interface: eth0}\n  - {port: 80, interface: 2001:db8::5}\n",
+			want: []string{"ci.services[1].port E_PORT_DUPLICATE"},
+		},
+		{
+			name: "address and its interface",
+			doc:  interfaces + "  - {port: 80, interface: 10.0.1.5}\n  - {port: 80, interface: eth0}\n",
+			want: []string{"ci.services[1].port E_PORT_DUPLICATE"},
+		},
+		{
+			name: "different addresses of an interface",
+			doc:  interfaces + "  - {port: 80, interface: 10.0.0.5}\n  - {port: 80, interface: 10.0.1.5}\n",
+		},
+		{
+			name: "different interfaces",
+			doc:  interfaces + "  - {port: 80, interface: eth0}\n  - {port: 80, interface: eth1}\n",
+		},
+		{
+			name: "unknown interface",
+			doc:  interfaces + "  - {port: 80, interface: eth7}\n",
+			want: []string{"ci.services[0].interface E_SERVICE_INTERFACE_UNKNOWN"},
+		},
+		{
+			name: "address of no interface",
+			doc:  interfaces + "  - {port: 80, interface: 10.9.9.9}\n",
+			want: []string{"ci.services[0].interface E_SERVICE_INTERFACE_UNKNOWN"},
+		},
+	})
+}
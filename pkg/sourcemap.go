@@ -0,0 +1,238 @@
+package pkg
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/template/parse"
+)
+
+// RangeFrame identifies one iteration of a range action in the template.
+type RangeFrame struct {
+	Expr string `json:"expr"`
+	Item int    `json:"item"`
+}
+
+// SourceLocation is the template position which produced an output line.
+type SourceLocation struct {
+	Line   int          `json:"line"`
+	Ranges []RangeFrame `json:"ranges,omitempty"`
+}
+
+// SourceMap maps lines of the rendered .tex output back to the template.
+type SourceMap struct {
+	Name  string
+	lines []SourceLocation
+}
+
+// Lookup returns the template location of the 1-based output line.
+func (m *SourceMap) Lookup(line int) (SourceLocation, bool) {
+	if m == nil || line < 1 || line > len(m.lines) {
+		return SourceLocation{}, false
+	}
+	return m.lines[line-1], true
+}
+
+// Describe formats the template location of the 1-based output line, e.g.
+// "template.tex:147 inside range .CI.ReleaseVersions, item 3".
+func (m *SourceMap) Describe(line int) string {
+	loc, ok := m.Lookup(line)
+	if !ok {
+		return ""
+	}
+	name := m.Name
+	if name == "" {
+		name = "template"
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s:%d", name, loc.Line)
+	for i := len(loc.Ranges) - 1; i >= 0; i-- {
+		fmt.Fprintf(&b, " inside %s, item %d", loc.Ranges[i].Expr, loc.Ranges[i].Item)
+	}
+	return b.String()
+}
+
+// Annotate resolves diagnostics reported against the rendered document to
+// their template location.
+func (m *SourceMap) Annotate(diags []Diagnostic) {
+	for i := range diags {
+		if diags[i].File != TeXFileName {
+			continue
+		}
+		if loc, ok := m.Lookup(diags[i].Line); ok {
+			diags[i].TemplateLine = loc.Line
+			diags[i].Source = m.Describe(diags[i].Line)
+		}
+	}
+}
+
+// WriteTo writes one tab separated "output line, template location" pair
+// per line of the rendered document.
+func (m *SourceMap) WriteTo(w io.Writer) (int64, error) {
+	var n int64
+	for i := range m.lines {
+		c, err := fmt.Fprintf(w, "%d\t%s\n", i+1, m.Describe(i+1))
+		n += int64(c)
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+const markerDelim = '\x00'
+
+type markKind int
+
+const (
+	markNode markKind = iota
+	markText
+	markRangeStart
+	markRangeItem
+	markRangeEnd
+)
+
+type mark struct {
+	kind markKind
+	line int
+	expr string
+}
+
+// sourceMapper instruments parsed templates with marker text nodes and
+// strips them from the output again, recording which template line each
+// output line originates from.
+type sourceMapper struct {
+	src   string
+	marks []mark
+}
+
+func (s *sourceMapper) lineOf(pos parse.Pos) int {
+	p := int(pos)
+	if p > len(s.src) {
+		p = len(s.src)
+	}
+	return 1 + strings.Count(s.src[:p], "\n")
+}
+
+func (s *sourceMapper) marker(m mark) parse.Node {
+	s.marks = append(s.marks, m)
+	text := string(markerDelim) + strconv.Itoa(len(s.marks)-1) + string(markerDelim)
+	return &parse.TextNode{NodeType: parse.NodeText, Text: []byte(text)}
+}
+
+func (s *sourceMapper) instrument(t *parse.Tree) {
+	if t == nil || t.Root == nil {
+		return
+	}
+	s.instrumentList(t.Root)
+}
+
+func (s *sourceMapper) instrumentList(l *parse.ListNode) {
+	if l == nil {
+		return
+	}
+	nodes := make([]parse.Node, 0, 2*len(l.Nodes))
+	for _, n := range l.Nodes {
+		line := s.lineOf(n.Position())
+		switch n := n.(type) {
+		case *parse.TextNode:
+			nodes = append(nodes, s.marker(mark{kind: markText, line: line}), n)
+		case *parse.ActionNode, *parse.TemplateNode:
+			nodes = append(nodes, s.marker(mark{kind: markNode, line: line}), n)
+		case *parse.IfNode:
+			s.instrumentList(n.List)
+			s.instrumentList(n.ElseList)
+			nodes = append(nodes, n)
+		case *parse.WithNode:
+			s.instrumentList(n.List)
+			s.instrumentList(n.ElseList)
+			nodes = append(nodes, n)
+		case *parse.RangeNode:
+			s.instrumentList(n.List)
+			s.instrumentList(n.ElseList)
+			expr := "range " + n.Pipe.String()
+			if n.List != nil {
+				n.List.Nodes = append([]parse.Node{s.marker(mark{kind: markRangeItem})}, n.List.Nodes...)
+			}
+			nodes = append(nodes,
+				s.marker(mark{kind: markRangeStart, line: line, expr: expr}),
+				n,
+				s.marker(mark{kind: markRangeEnd}),
+			)
+		default:
+			nodes = append(nodes, n)
+		}
+	}
+	l.Nodes = nodes
+}
+
+// markAt returns the marker at the start of b and its length. Anything
+// else starting with markerDelim is not a marker.
+func (s *sourceMapper) markAt(b []byte) (mark, int, bool) {
+	if len(b) == 0 || b[0] != markerDelim {
+		return mark{}, 0, false
+	}
+	end := bytes.IndexByte(b[1:], markerDelim)
+	if end < 0 {
+		return mark{}, 0, false
+	}
+	id, err := strconv.Atoi(string(b[1 : 1+end]))
+	if err != nil || id < 0 || id >= len(s.marks) {
+		return mark{}, 0, false
+	}
+	return s.marks[id], end + 2, true
+}
+
+// strip removes all markers from out and builds the source map.
+func (s *sourceMapper) strip(out []byte) ([]byte, *SourceMap) {
+	var (
+		buf     bytes.Buffer
+		sm      = &SourceMap{}
+		line    = 1
+		isText  bool
+		ranges  []RangeFrame
+		pending = true
+	)
+	record := func() {
+		sm.lines = append(sm.lines, SourceLocation{Line: line, Ranges: append([]RangeFrame(nil), ranges...)})
+		pending = false
+	}
+
+	for i := 0; i < len(out); i++ {
+		c := out[i]
+		if m, n, ok := s.markAt(out[i:]); ok {
+			i += n - 1
+
+			switch m.kind {
+			case markText, markNode:
+				line, isText = m.line, m.kind == markText
+			case markRangeStart:
+				line, isText = m.line, false
+				ranges = append(ranges, RangeFrame{Expr: m.expr})
+			case markRangeItem:
+				if len(ranges) > 0 {
+					ranges[len(ranges)-1].Item++
+				}
+			case markRangeEnd:
+				if len(ranges) > 0 {
+					ranges = ranges[:len(ranges)-1]
+				}
+			}
+			continue
+		}
+
+		if pending {
+			record()
+		}
+		buf.WriteByte(c)
+		if c == '\n' {
+			pending = true
+			if isText {
+				line++
+			}
+		}
+	}
+	return buf.Bytes(), sm
+}
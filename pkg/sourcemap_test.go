@@ -0,0 +1,146 @@
+package pkg
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestSourceMap(t *testing.T) {
+	name := "srv"
+	ntp := []*string{&name, &name}
+	root := Root{CI: &CI{Configuration: &Configuration{Name: &name, NTP: ntp}}}
+	tests := []struct {
+		name string
+		tmpl string
+		// want is the described template location of every output line.
+		want []string
+	}{
+		{
+			name: "text",
+			tmpl: "a\nb\nc\n",
+			want: []string{"t:1", "t:2", "t:3"},
+		},
+		{
+			name: "action",
+			tmpl: "a\n<< .CI.Configuration.Name >>\nb\n",
+			want: []string{"t:1", "t:2", "t:3"},
+		},
+		{
+			name: "multi-line value",
+			tmpl: "a\n<< raw \"x\\ny\" >>\nb\n",
+			want: []string{"t:1", "t:2", "t:2", "t:3"},
+		},
+		{
+			name: "if",
+			tmpl: "<< if .CI.Configuration.Name >>\nyes\n<< else >>\nno\n<< end >>\nend\n",
+			want: []string{"t:1", "t:2", "t:5", "t:6"},
+		},
+		{
+			name: "range",
+			tmpl: "head\n<< range .CI.Configuration.NTP >>\nitem << . >>\n<< end >>\ntail\n",
+			want: []string{
+				"t:1",
+				"t:2 inside range .CI.Configuration.NTP, item 1",
+				"t:3 inside range .CI.Configuration.NTP, item 1",
+				"t:2 inside range .CI.Configuration.NTP, item 2",
+				"t:3 inside range .CI.Configuration.NTP, item 2",
+				"t:4",
+				"t:5",
+			},
+		},
+		{
+			name: "nested range",
+			tmpl: "<< range .CI.Configuration.NTP >><< range $.CI.Configuration.NTP >>x\n<< end >><< end >>",
+			want: []string{
+				"t:1 inside range $.CI.Configuration.NTP, item 1 inside range .CI.Configuration.NTP, item 1",
+				"t:1 inside range $.CI.Configuration.NTP, item 2 inside range .CI.Configuration.NTP, item 1",
+				"t:1 inside range $.CI.Configuration.NTP, item 1 inside range .CI.Configuration.NTP, item 2",
+				"t:1 inside range $.CI.Configuration.NTP, item 2 inside range .CI.Configuration.NTP, item 2",
+			},
+		},
+		{
+			name: "template",
+			tmpl: "<< define \"n\" >>\nin\n<< end >>a\n<< template \"n\" >>\n",
+			want: []string{"t:3", "t:1", "t:2", "t:4"},
+		},
+	}
+	for _, tt := range tests {
+		out, sm, err := ParseTempl(strings.NewReader(tt.tmpl), root, true)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if bytes.IndexByte(out, markerDelim) >= 0 {
+			t.Errorf("%s: output contains markers: %q", tt.name, out)
+		}
+		sm.Name = "t"
+		lines := strings.Count(string(out), "\n")
+		if !bytes.HasSuffix(out, []byte("\n")) {
+			lines++
+		}
+		var got []string
+		for i := 1; i <= lines; i++ {
+			got = append(got, sm.Describe(i))
+		}
+		if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+			t.Errorf("%s: output %q mapped to\n%s\nwant\n%s", tt.name, out, strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+		}
+	}
+}
+
+func TestSourceMapLookup(t *testing.T) {
+	_, sm, err := ParseTempl(strings.NewReader("a\nb\n"), Root{}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		line int
+		want int
+		ok   bool
+	}{
+		{0, 0, false},
+		{1, 1, true},
+		{2, 2, true},
+		{3, 0, false},
+	}
+	for _, tt := range tests {
+		loc, ok := sm.Lookup(tt.line)
+		if ok != tt.ok || loc.Line != tt.want {
+			t.Errorf("Lookup(%d) = %d, %v, want %d, %v", tt.line, loc.Line, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestSourceMapAnnotate(t *testing.T) {
+	_, sm, err := ParseTempl(strings.NewReader("a\n<< range .CI.Configuration.NTP >>\nx\n<< end >>"), Root{CI: &CI{Configuration: &Configuration{NTP: []*string{ptr("1.1.1.1")}}}}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sm.Name = "template.tex"
+	diags := []Diagnostic{
+		{File: TeXFileName, Line: 3, Message: "in the document"},
+		{File: "other.sty", Line: 3, Message: "in a package"},
+	}
+	sm.Annotate(diags)
+	if d := diags[0]; d.TemplateLine != 3 || d.Source != "template.tex:3 inside range .CI.Configuration.NTP, item 1" {
+		t.Errorf("document diagnostic annotated with %d %q", d.TemplateLine, d.Source)
+	}
+	if d := diags[1]; d.TemplateLine != 0 || d.Source != "" {
+		t.Errorf("package diagnostic annotated with %d %q", d.TemplateLine, d.Source)
+	}
+}
+
+func TestParseTemplNUL(t *testing.T) {
+	tests := []string{"a\x00b", "\x000\x00", "<< raw \"\\x00\" >>x"}
+	for _, tmpl := range tests {
+		out, _, err := ParseTempl(strings.NewReader(tmpl), Root{}, true)
+		if err != nil {
+			t.Errorf("%q: %v", tmpl, err)
+			continue
+		}
+		if bytes.IndexByte(out, 0) >= 0 {
+			t.Errorf("%q: output contains NUL: %q", tmpl, out)
+		}
+	}
+}
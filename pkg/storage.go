@@ -0,0 +1,63 @@
+package pkg
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+var reDriveLetter = regexp.MustCompile(`^[A-Za-z]:\\?$`)
+
+// mountPattern matches the mount points validateMount accepts, for the JSON
+// Schema.
+const mountPattern = `^(/.*|[A-Za-z]:\\?)$`
+
+func validatePositiveInt(_ string, p *int) error {
+	if p == nil {
+		return nil
+	}
+	if *p <= 0 {
+		return errorf(CodeNotPositive, "must be > 0, got %d", *p)
+	}
+	return nil
+}
+
+func validateMount(_ string, p *string) error {
+	s := strOrEmpty(p)
+	if s == "" {
+		return nil
+	}
+	if !strings.HasPrefix(s, "/") && !reDriveLetter.MatchString(s) {
+		return errorf(CodeMountInvalid, "invalid mount point %q (expected an absolute path like /var or a drive letter like D:)", s)
+	}
+	return nil
+}
+
+// mountKey normalizes a mount point for comparison, so that /data/ equals
+// /data and d:\ equals D:.
+func mountKey(s string) string {
+	if reDriveLetter.MatchString(s) {
+		return strings.ToUpper(s[:2])
+	}
+	return path.Clean(s)
+}
+
+// validateStorageSet checks that no two volumes share a mount point.
+func validateStorageSet(p string, list []*Volume) error {
+	var me MultiError
+	mounts := map[string]string{}
+	for i, v := range list {
+		if v == nil || isEmpty(v.Mount) || validateMount("", v.Mount) != nil {
+			continue
+		}
+		mp := fmt.Sprintf("%s[%d].mount", p, i)
+		key := mountKey(strOrEmpty(v.Mount))
+		if other, dup := mounts[key]; dup {
+			me.add(mp, errorf(CodeMountDuplicate, "duplicate mount point %q (also used by %s)", strOrEmpty(v.Mount), other))
+		} else {
+			mounts[key] = mp
+		}
+	}
+	return me.ToError()
+}
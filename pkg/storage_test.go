@@ -0,0 +1,53 @@
+package pkg
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateStorage(t *testing.T) {
+	runValidationTests(t, []validationTest{
+		{
+			name: "valid",
+			doc:  "ci:\n  storage:\n  - {name: root, size: 40, mount: /}\n  - {name: data, size: 100, mount: /var/lib/pgsql}\n  - {name: win, size: 60, mount: 'D:'}\n  - {name: win2, mount: 'E:\\'}\n",
+		},
+		{
+			name: "size not positive",
+			doc:  "ci:\n  storage:\n  - {size: 0}\n  - {size: -5}\n",
+			want: []string{"ci.storage[0].size E_NOT_POSITIVE", "ci.storage[1].size E_NOT_POSITIVE"},
+		},
+		{
+			name: "invalid mount",
+			doc:  "ci:\n  storage:\n  - {mount: var/lib}\n  - {mount: 'DD:'}\n",
+			want: []string{"ci.storage[0].mount E_MOUNT_INVALID", "ci.storage[1].mount E_MOUNT_INVALID"},
+		},
+		{
+			name: "duplicate mount",
+			doc:  "ci:\n  storage:\n  - {mount: /data}\n  - {mount: /data/}\n  - {mount: 'd:'}\n  - {mount: 'D:\\'}\n",
+			want: []string{"ci.storage[1].mount E_MOUNT_DUPLICATE", "ci.storage[3].mount E_MOUNT_DUPLICATE"},
+		},
+	})
+}
+
+func TestDeref(t *testing.T) {
+	yes, no := true, false
+	tests := []struct {
+		encrypted *bool
+		want      string
+	}{
+		{&yes, "Yes"},
+		{&no, "No"},
+		{nil, "-"},
+	}
+	const tmpl = `<< range .CI.Storage >><< if .Encrypted >><< if deref .Encrypted >>Yes<< else >>No<< end >><< else >>-<< end >><< end >>`
+	for _, tt := range tests {
+		root := Root{CI: &CI{Storage: []*Volume{{Encrypted: tt.encrypted}}}}
+		out, _, err := ParseTempl(strings.NewReader(tmpl), root, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(out) != tt.want {
+			t.Errorf("encrypted %v: got %q, want %q", printable(tt.encrypted), out, tt.want)
+		}
+	}
+}
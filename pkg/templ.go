@@ -7,16 +7,49 @@ import (
 	"text/template"
 )
 
-func ParseTempl(texReader io.Reader, root Root, strict bool) ([]byte, error) {
+// ParseTempl renders the template with the given data. Alongside the
+// rendered document it returns a SourceMap resolving output lines to the
+// template lines which produced them.
+func ParseTempl(texReader io.Reader, root Root, strict bool) ([]byte, *SourceMap, error) {
 	texBytes, err := io.ReadAll(texReader)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	tex := string(texBytes)
+	// NUL delimits the markers of the source map, and has no use in TeX.
+	tex := strings.ReplaceAll(string(texBytes), "\x00", "")
 
+	tmpl, err := newTemplate(strict).Parse(tex)
+	if err != nil {
+		return nil, nil, err
+	}
+	mapper := &sourceMapper{src: tex}
+	for _, t := range tmpl.Templates() {
+		autoEscape(t.Tree)
+		mapper.instrument(t.Tree)
+	}
+
+	var processedTmplBuff bytes.Buffer
+	if err := tmpl.Execute(&processedTmplBuff, &root); err != nil {
+		return nil, nil, err
+	}
+
+	out, sm := mapper.strip(processedTmplBuff.Bytes())
+	return out, sm, nil
+}
+
+func newTemplate(strict bool) *template.Template {
 	funcMap := template.FuncMap{
-		"upper": strings.ToUpper,
-		"lower": strings.ToLower,
+		"upper":        strings.ToUpper,
+		"lower":        strings.ToLower,
+		"raw":          rawTeX,
+		"latex":        rawTeX,
+		"ipv4":         func(list []*string) []string { return filterAddresses(4, list) },
+		"ipv6":         func(list []*string) []string { return filterAddresses(6, list) },
+		"addrIP":       addressIP,
+		"addrPrefix":   addressPrefix,
+		"netmask":      addressNetmask,
+		"deref":        deref,
+		escapeFuncName: texEscape,
 	}
 
 	tmpl := template.New("latex").
@@ -28,16 +61,5 @@ func ParseTempl(texReader io.Reader, root Root, strict bool) ([]byte, error) {
 	} else {
 		tmpl = tmpl.Option("missingkey=zero")
 	}
-
-	tmpl, err = tmpl.Parse(tex)
-	if err != nil {
-		return nil, err
-	}
-
-	var processedTmplBuff bytes.Buffer
-	if err := tmpl.Execute(&processedTmplBuff, &root); err != nil {
-		return nil, err
-	}
-
-	return processedTmplBuff.Bytes(), nil
+	return tmpl
 }
@@ -1,6 +1,7 @@
 package pkg
 
 import (
+	"errors"
 	"fmt"
 	"net"
 	"regexp"
@@ -9,15 +10,41 @@ import (
 )
 
 type ValidationError struct {
-	Path string
-	Err  error
+	Path     string
+	Code     string
+	Severity Severity
+	Err      error
+
+	// File, Line and Column locate the field in the source document,
+	// see Root.Locate. Line and Column are 0 if unknown.
+	File   string
+	Line   int
+	Column int
 }
 
 func (ve ValidationError) Error() string {
-	if ve.Path == "" {
-		return ve.Err.Error()
+	msg := ve.Err.Error()
+	if ve.Severity != SeverityError {
+		msg = fmt.Sprintf("%s: %s", ve.Severity, msg)
+	}
+	if ve.Path != "" {
+		msg = fmt.Sprintf("%s: %s", ve.Path, msg)
+	}
+	if pos := ve.Position(); pos != "" {
+		msg = fmt.Sprintf("%s: %s", pos, msg)
 	}
-	return fmt.Sprintf("%s: %v", ve.Path, ve.Err)
+	return msg
+}
+
+// Position formats the location as "file:line:column", leaving out the
+// parts which are unknown.
+func (ve ValidationError) Position() string {
+	pos := ve.File
+	if ve.Line > 0 {
+		pos = fmt.Sprintf("%s:%d:%d", pos, ve.Line, ve.Column)
+		pos = strings.TrimPrefix(pos, ":")
+	}
+	return pos
 }
 
 func (ve ValidationError) Unwrap() error { return ve.Err }
@@ -38,11 +65,44 @@ func (m MultiError) Error() string {
 	return b.String()
 }
 
+// add records err at path as error. Nested MultiErrors already carry full
+// paths and severities and are flattened, so every ValidationError names
+// the field it is about.
 func (m *MultiError) add(path string, err error) {
+	m.addSeverity(path, SeverityError, err)
+}
+
+// warn records err at path as warning, which does not block rendering.
+func (m *MultiError) warn(path string, err error) {
+	m.addSeverity(path, SeverityWarning, err)
+}
+
+func (m *MultiError) addSeverity(path string, severity Severity, err error) {
 	if err == nil {
 		return
 	}
-	*m = append(*m, ValidationError{Path: path, Err: err})
+	var nested MultiError
+	if errors.As(err, &nested) {
+		*m = append(*m, nested...)
+		return
+	}
+	code := CodeInvalid
+	var ce *codedError
+	if errors.As(err, &ce) {
+		code = ce.code
+	}
+	*m = append(*m, ValidationError{Path: path, Code: code, Severity: severity, Err: err})
+}
+
+// AtLeast returns the findings as severe as min or more.
+func (m MultiError) AtLeast(min Severity) MultiError {
+	var out MultiError
+	for _, ve := range m {
+		if ve.Severity.AtLeast(min) {
+			out = append(out, ve)
+		}
+	}
+	return out
 }
 
 func (m MultiError) ToError() error {
@@ -52,12 +112,117 @@ func (m MultiError) ToError() error {
 	return m
 }
 
-const dateLayout = "02.01.2006"
+// Codes identify the rule behind a Finding. They are stable, clients may
+// map them to form fields or translated messages.
+const (
+	CodeInvalid = "E_INVALID"
+	CodeDecode  = "E_DECODE"
+
+	CodeDateFormat          = "E_DATE_FORMAT"
+	CodeVersionFormat       = "E_VERSION_FORMAT"
+	CodeNegative            = "E_NEGATIVE"
+	CodeVLANRange           = "E_VLAN_RANGE"
+	CodeIPInvalid           = "E_IP_INVALID"
+	CodeIPDuplicate         = "E_IP_DUPLICATE"
+	CodeFQDNNoDot           = "E_FQDN_NO_DOT"
+	CodeFQDNLabel           = "E_FQDN_LABEL"
+	CodeFQDNLength          = "E_FQDN_LENGTH"
+	CodeSubnetInvalid       = "E_SUBNET_INVALID"
+	CodeSubnetNotIPv4       = "E_SUBNET_NOT_IPV4"
+	CodeSubnetNotContiguous = "E_SUBNET_NOT_CONTIGUOUS"
+	CodeSubnetPrefixRange   = "E_SUBNET_PREFIX_RANGE"
+	CodeHostInvalid         = "E_HOST_INVALID"
+	CodeHostLength          = "E_HOST_LENGTH"
+
+	CodeStaticIPMissing      = "E_STATIC_IP_MISSING"
+	CodeStaticSubnetMissing  = "E_STATIC_SUBNET_MISSING"
+	CodeDHCPIPSet            = "E_DHCP_IP_SET"
+	CodeDHCPSubnetSet        = "E_DHCP_SUBNET_SET"
+	CodeIPNetworkAddress     = "E_IP_NETWORK_ADDRESS"
+	CodeIPBroadcastAddress   = "E_IP_BROADCAST_ADDRESS"
+	CodeGatewayOutsideSubnet = "E_GATEWAY_OUTSIDE_SUBNET"
+	CodeSubnetOverlap        = "E_SUBNET_OVERLAP"
+
+	CodeAddressInvalid    = "E_ADDRESS_INVALID"
+	CodeIPv6ModeInvalid   = "E_IPV6_MODE_INVALID"
+	CodeIPv6StaticMissing = "E_IPV6_STATIC_MISSING"
+	CodeIPv6AddressSet    = "E_IPV6_ADDRESS_SET"
+	CodeGatewayFamily     = "E_GATEWAY_FAMILY"
+
+	CodeVersionDuplicate      = "E_VERSION_DUPLICATE"
+	CodeVersionOrder          = "E_VERSION_ORDER"
+	CodeDateOrder             = "E_DATE_ORDER"
+	CodeReleaseUnknownVersion = "E_RELEASE_UNKNOWN_VERSION"
+	CodeAuditBeforeVersion    = "E_AUDIT_BEFORE_VERSION"
+
+	CodeNotPositive    = "E_NOT_POSITIVE"
+	CodeMountInvalid   = "E_MOUNT_INVALID"
+	CodeMountDuplicate = "E_MOUNT_DUPLICATE"
+
+	CodePortRange               = "E_PORT_RANGE"
+	CodeProtocolInvalid         = "E_PROTOCOL_INVALID"
+	CodePortDuplicate           = "E_PORT_DUPLICATE"
+	CodeServiceInterfaceUnknown = "E_SERVICE_INTERFACE_UNKNOWN"
+
+	CodePolicyEval = "E_POLICY_EVAL"
+
+	CodeNTPMissing         = "W_NTP_MISSING"
+	CodeDisasterLvlMissing = "W_DISASTER_LVL_MISSING"
+)
+
+// codedError is a validation message carrying the code of its rule.
+type codedError struct {
+	code string
+	msg  string
+}
+
+func (e *codedError) Error() string { return e.msg }
+
+func errorf(code, format string, args ...any) error {
+	return &codedError{code: code, msg: fmt.Sprintf(format, args...)}
+}
+
+// Finding is the machine-readable form of a ValidationError.
+type Finding struct {
+	Path     string   `json:"path"`
+	Code     string   `json:"code"`
+	Message  string   `json:"message"`
+	Severity Severity `json:"severity"`
+	File     string   `json:"file,omitempty"`
+	Line     int      `json:"line,omitempty"`
+	Column   int      `json:"column,omitempty"`
+}
+
+// Findings lists the findings in their machine-readable form.
+func (m MultiError) Findings() []Finding {
+	findings := make([]Finding, 0, len(m))
+	for _, ve := range m {
+		findings = append(findings, Finding{
+			Path:     ve.Path,
+			Code:     ve.Code,
+			Message:  ve.Err.Error(),
+			Severity: ve.Severity,
+			File:     ve.File,
+			Line:     ve.Line,
+			Column:   ve.Column,
+		})
+	}
+	return findings
+}
+
+const (
+	dateLayout = "02.01.2006"
+	// datePattern matches dateLayout, for the JSON Schema.
+	datePattern = `^\d{2}\.\d{2}\.\d{4}$`
+
+	minVLAN = 1
+	maxVLAN = 4094
+)
 
 var (
 	reVersionNumber    = regexp.MustCompile(`^\d+(?:\.\d+)*$`)
 	reHostnameLabel    = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?$`)
-	reSubnetPrefixOnly = regexp.MustCompile(`^/(?:[0-9]|[12][0-9]|3[0-2])$`)
+	reSubnetPrefixOnly = regexp.MustCompile(`^/(?:[0-9]|[1-9][0-9]|1[01][0-9]|12[0-8])$`)
 )
 
 func strOrEmpty(p *string) string {
@@ -67,19 +232,24 @@ func strOrEmpty(p *string) string {
 	return strings.TrimSpace(*p)
 }
 
-func boolOrFalse(p *bool) bool {
-	return p != nil && *p
-}
-
 func isEmpty(p *string) bool { return p == nil || strings.TrimSpace(*p) == "" }
 
+func hasValue(list []*string) bool {
+	for _, p := range list {
+		if !isEmpty(p) {
+			return true
+		}
+	}
+	return false
+}
+
 func validateDate(_ string, p *string) error { // path not needed for inner check
 	s := strOrEmpty(p)
 	if s == "" {
 		return nil
 	}
 	if _, err := time.Parse(dateLayout, s); err != nil {
-		return fmt.Errorf("invalid date %q (expected %s)", s, dateLayout)
+		return errorf(CodeDateFormat, "invalid date %q (expected %s)", s, dateLayout)
 	}
 	return nil
 }
@@ -90,7 +260,7 @@ func validateVersionNumber(_ string, p *string) error {
 		return nil
 	}
 	if !reVersionNumber.MatchString(s) {
-		return fmt.Errorf("invalid version number %q (expected digits with optional dots, e.g. 1.2.3)", s)
+		return errorf(CodeVersionFormat, "invalid version number %q (expected digits with optional dots, e.g. 1.2.3)", s)
 	}
 	return nil
 }
@@ -100,7 +270,7 @@ func validateNonNegativeInt(_ string, p *int) error {
 		return nil
 	}
 	if *p < 0 {
-		return fmt.Errorf("must be ≥ 0, got %d", *p)
+		return errorf(CodeNegative, "must be ≥ 0, got %d", *p)
 	}
 	return nil
 }
@@ -109,8 +279,8 @@ func validateVLAN(_ string, p *int) error {
 	if p == nil {
 		return nil
 	}
-	if *p < 1 || *p > 4094 {
-		return fmt.Errorf("VLAN must be in [1..4094], got %d", *p)
+	if *p < minVLAN || *p > maxVLAN {
+		return errorf(CodeVLANRange, "VLAN must be in [%d..%d], got %d", minVLAN, maxVLAN, *p)
 	}
 	return nil
 }
@@ -121,7 +291,7 @@ func validateIP(_ string, p *string) error {
 		return nil
 	}
 	if net.ParseIP(s) == nil {
-		return fmt.Errorf("invalid IP address %q", s)
+		return errorf(CodeIPInvalid, "invalid IP address %q", s)
 	}
 	return nil
 }
@@ -135,11 +305,11 @@ func validateIPList(path string, list []*string) error {
 		}
 		v := strings.TrimSpace(*sp)
 		if net.ParseIP(v) == nil {
-			me.add(fmt.Sprintf("%s[%d]", path, i), fmt.Errorf("invalid IP address %q", v))
+			me.add(fmt.Sprintf("%s[%d]", path, i), errorf(CodeIPInvalid, "invalid IP address %q", v))
 			continue
 		}
 		if _, dup := seen[v]; dup {
-			me.add(fmt.Sprintf("%s[%d]", path, i), fmt.Errorf("duplicate IP %q", v))
+			me.add(fmt.Sprintf("%s[%d]", path, i), errorf(CodeIPDuplicate, "duplicate IP %q", v))
 		} else {
 			seen[v] = struct{}{}
 		}
@@ -154,20 +324,22 @@ func validateFQDN(_ string, p *string) error {
 	}
 	labels := strings.Split(s, ".")
 	if len(labels) < 2 {
-		return fmt.Errorf("invalid FQDN %q (need at least one dot)", s)
+		return errorf(CodeFQDNNoDot, "invalid FQDN %q (need at least one dot)", s)
 	}
 	for _, lbl := range labels {
 		if len(lbl) == 0 || len(lbl) > 63 || !reHostnameLabel.MatchString(lbl) {
-			return fmt.Errorf("invalid FQDN %q (bad label %q)", s, lbl)
+			return errorf(CodeFQDNLabel, "invalid FQDN %q (bad label %q)", s, lbl)
 		}
 	}
 	if len(s) > 253 {
-		return fmt.Errorf("invalid FQDN %q (too long)", s)
+		return errorf(CodeFQDNLength, "invalid FQDN %q (too long)", s)
 	}
 	return nil
 }
 
-// Accepts either "/N" prefix or dotted IPv4 mask like "255.255.255.0".
+// Accepts either "/N" prefix up to /128 or dotted IPv4 mask like
+// "255.255.255.0". Whether the prefix fits the address family of the ip is
+// checked by Interface.Validate.
 func validateSubnetMask(_ string, p *string) error {
 	s := strOrEmpty(p)
 	if s == "" {
@@ -178,15 +350,15 @@ func validateSubnetMask(_ string, p *string) error {
 	}
 	ip := net.ParseIP(s)
 	if ip == nil {
-		return fmt.Errorf("invalid subnet %q (use /N or dotted mask)", s)
+		return errorf(CodeSubnetInvalid, "invalid subnet %q (use /N or dotted mask)", s)
 	}
 	ip = ip.To4()
 	if ip == nil {
-		return fmt.Errorf("invalid IPv4 mask %q", s)
+		return errorf(CodeSubnetNotIPv4, "invalid IPv4 mask %q", s)
 	}
 	mask := net.IPMask(ip)
 	if !isContiguousIPv4Mask(mask) {
-		return fmt.Errorf("invalid dotted netmask %q (must be contiguous)", s)
+		return errorf(CodeSubnetNotContiguous, "invalid dotted netmask %q (must be contiguous)", s)
 	}
 	return nil
 }
@@ -219,11 +391,110 @@ func validateHostnameOrIP(_ string, p *string) error {
 	labels := strings.Split(s, ".")
 	for _, lbl := range labels {
 		if len(lbl) == 0 || len(lbl) > 63 || !reHostnameLabel.MatchString(lbl) {
-			return fmt.Errorf("invalid host or IP %q", s)
+			return errorf(CodeHostInvalid, "invalid host or IP %q", s)
 		}
 	}
 	if len(s) > 253 {
-		return fmt.Errorf("invalid host %q (too long)", s)
+		return errorf(CodeHostLength, "invalid host %q (too long)", s)
 	}
 	return nil
 }
+
+func validateCIDRList(path string, list []*string) error {
+	var me MultiError
+	for i, sp := range list {
+		s := strOrEmpty(sp)
+		if s == "" {
+			continue
+		}
+		if _, _, err := net.ParseCIDR(s); err != nil {
+			me.add(fmt.Sprintf("%s[%d]", path, i), errorf(CodeAddressInvalid, "invalid address %q (expected CIDR notation, e.g. 10.0.0.5/24 or 2001:db8::5/64)", s))
+		}
+	}
+	return me.ToError()
+}
+
+func validateIPv6Mode(_ string, p *string) error {
+	switch s := strOrEmpty(p); s {
+	case "", IPv6ModeStatic, IPv6ModeSLAAC, IPv6ModeDHCPv6:
+		return nil
+	default:
+		return errorf(CodeIPv6ModeInvalid, "invalid ipv6-mode %q (expected static, slaac or dhcpv6)", s)
+	}
+}
+
+// validateGateway checks that gw has the expected address family and lies
+// inside a network of the interface. IPv6 gateways may be link-local.
+func validateGateway(gw net.IP, ipv4 bool, addrs []interfaceAddr) error {
+	if gw == nil {
+		return nil
+	}
+	if (gw.To4() != nil) != ipv4 {
+		if ipv4 {
+			return errorf(CodeGatewayFamily, "gateway %s is not IPv4 (use gateway6)", gw)
+		}
+		return errorf(CodeGatewayFamily, "gateway6 %s is not IPv6 (use gateway)", gw)
+	}
+	if !ipv4 && gw.IsLinkLocalUnicast() {
+		return nil
+	}
+	var networks []string
+	for _, a := range addrs {
+		if a.network == nil || a.isIPv4() != ipv4 {
+			continue
+		}
+		if a.network.Contains(gw) {
+			return nil
+		}
+		networks = append(networks, a.network.String())
+	}
+	if len(networks) == 0 {
+		return nil
+	}
+	field := "gateway"
+	if !ipv4 {
+		field = "gateway6"
+	}
+	return errorf(CodeGatewayOutsideSubnet, "%s %s is outside of %s", field, gw, strings.Join(networks, ", "))
+}
+
+// validateInterfaceSet checks rules across interfaces: an IP is used once
+// and overlapping subnets are only allowed within the same VLAN.
+func validateInterfaceSet(path string, list []*Interface) error {
+	type subnet struct {
+		path    string
+		network *net.IPNet
+		vlan    int
+	}
+	var me MultiError
+	ips := map[string]string{}
+	var subnets []subnet
+	for i, in := range list {
+		if in == nil {
+			continue
+		}
+		vlan := 0
+		if in.VLAN != nil {
+			vlan = *in.VLAN
+		}
+		for _, a := range interfaceAddrs(fmt.Sprintf("%s[%d]", path, i), in) {
+			if other, dup := ips[a.ip.String()]; dup {
+				me.add(a.path, errorf(CodeIPDuplicate, "duplicate IP %q (also used by %s)", a.ip, other))
+			} else {
+				ips[a.ip.String()] = a.path
+			}
+
+			if a.network == nil {
+				continue
+			}
+			for _, other := range subnets {
+				if other.vlan != vlan && networksOverlap(a.network, other.network) {
+					me.add(a.subnetPath, errorf(CodeSubnetOverlap, "subnet %s overlaps %s of %s on a different VLAN", a.network, other.network, other.path))
+					break
+				}
+			}
+			subnets = append(subnets, subnet{path: a.subnetPath, network: a.network, vlan: vlan})
+		}
+	}
+	return me.ToError()
+}
@@ -0,0 +1,210 @@
+package pkg
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const maxSummarizedChanges = 5
+
+// AppendVersion appends a Version to next which summarises the changes since
+// prev. The version history lists themselves are not considered changes. It
+// returns nil and leaves next untouched if nothing changed.
+func AppendVersion(prev, next *CI, user string, now time.Time) *Version {
+	changes := DiffCI(withoutHistory(prev), withoutHistory(next))
+	if len(changes) == 0 {
+		return nil
+	}
+
+	latest := ""
+	for _, ci := range []*CI{prev, next} {
+		if ci == nil {
+			continue
+		}
+		for _, v := range ci.Versions {
+			if v != nil && reVersionNumber.MatchString(strOrEmpty(v.Number)) && compareVersionNumbers(strOrEmpty(v.Number), latest) > 0 {
+				latest = strOrEmpty(v.Number)
+			}
+		}
+	}
+
+	number := nextVersionNumber(latest)
+	date := now.Format(dateLayout)
+	description := summarizeChanges(changes)
+	v := &Version{Number: &number, Date: &date, Description: &description}
+	if user != "" {
+		v.User = &user
+	}
+	next.Versions = append(next.Versions, v)
+	return v
+}
+
+func withoutHistory(ci *CI) *CI {
+	if ci == nil {
+		return nil
+	}
+	c := *ci
+	c.Versions, c.AuditVersions, c.ReleaseVersions = nil, nil, nil
+	return &c
+}
+
+// nextVersionNumber increments the last component of a version number,
+// "1.0" becomes "1.1". Without previous version the first is "1.0".
+func nextVersionNumber(latest string) string {
+	if latest == "" {
+		return "1.0"
+	}
+	parts := strings.Split(latest, ".")
+	n, _ := strconv.Atoi(parts[len(parts)-1])
+	parts[len(parts)-1] = strconv.Itoa(n + 1)
+	return strings.Join(parts, ".")
+}
+
+// compareVersionNumbers compares dotted version numbers component-wise as
+// integers, missing components count as 0. An empty string sorts first.
+func compareVersionNumbers(a, b string) int {
+	switch {
+	case a == b:
+		return 0
+	case a == "":
+		return -1
+	case b == "":
+		return 1
+	}
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < max(len(as), len(bs)); i++ {
+		var x, y int
+		if i < len(as) {
+			x, _ = strconv.Atoi(as[i])
+		}
+		if i < len(bs) {
+			y, _ = strconv.Atoi(bs[i])
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// summarizeChanges describes changes in words only, as the description is
+// rendered into the document.
+func summarizeChanges(changes []Change) string {
+	parts := make([]string, 0, maxSummarizedChanges+1)
+	for i, c := range changes {
+		if i == maxSummarizedChanges {
+			parts = append(parts, fmt.Sprintf("%d more changes", len(changes)-i))
+			break
+		}
+		switch c.Kind {
+		case ChangeAdded:
+			parts = append(parts, "added "+c.Path)
+		case ChangeRemoved:
+			parts = append(parts, "removed "+c.Path)
+		default:
+			parts = append(parts, fmt.Sprintf("changed %s from %s to %s", c.Path, c.Old, c.New))
+		}
+	}
+	s := strings.Join(parts, "; ")
+	return strings.ToUpper(s[:1]) + s[1:]
+}
+
+// historyEntry is the number and date of an entry of a version list. date
+// is zero if missing or invalid.
+type historyEntry struct {
+	path   string
+	number string
+	date   time.Time
+}
+
+func newHistoryEntry(path string, number, date *string) historyEntry {
+	e := historyEntry{path: path}
+	if n := strOrEmpty(number); reVersionNumber.MatchString(n) {
+		e.number = n
+	}
+	e.date, _ = time.Parse(dateLayout, strOrEmpty(date))
+	return e
+}
+
+// validateHistory checks the version lists as a whole: numbers are unique
+// and increasing, dates don't decrease, releases refer to document versions
+// and audits don't predate the version they audit.
+func validateHistory(c *CI) error {
+	var versions, audits, releases []historyEntry
+	for i, v := range c.Versions {
+		if v != nil {
+			versions = append(versions, newHistoryEntry(fmt.Sprintf("ci.versions[%d]", i), v.Number, v.Date))
+		}
+	}
+	for i, v := range c.AuditVersions {
+		if v != nil {
+			audits = append(audits, newHistoryEntry(fmt.Sprintf("ci.audit_versions[%d]", i), v.Number, v.Date))
+		}
+	}
+	for i, v := range c.ReleaseVersions {
+		if v != nil {
+			releases = append(releases, newHistoryEntry(fmt.Sprintf("ci.release_versions[%d]", i), v.Number, v.Date))
+		}
+	}
+
+	var me MultiError
+	me.add("", validateHistoryOrder(versions))
+	me.add("", validateHistoryOrder(audits))
+	me.add("", validateHistoryOrder(releases))
+
+	for _, r := range releases {
+		if r.number != "" && findVersion(versions, r.number) == nil {
+			me.add(r.path+".number", errorf(CodeReleaseUnknownVersion, "release of version %s which is not in versions", r.number))
+		}
+	}
+	for _, a := range audits {
+		if a.number == "" || a.date.IsZero() {
+			continue
+		}
+		if v := findVersion(versions, a.number); v != nil && a.date.Before(v.date) {
+			me.add(a.path+".date", errorf(CodeAuditBeforeVersion, "audit on %s predates version %s of %s", a.date.Format(dateLayout), a.number, v.date.Format(dateLayout)))
+		}
+	}
+	return me.ToError()
+}
+
+func validateHistoryOrder(entries []historyEntry) error {
+	var me MultiError
+	var prevNumber, prevDate *historyEntry
+	for i := range entries {
+		e := &entries[i]
+		if e.number != "" {
+			if other := findVersion(entries[:i], e.number); other != nil {
+				me.add(e.path+".number", errorf(CodeVersionDuplicate, "duplicate version number %s (also used by %s)", e.number, other.path))
+			} else {
+				if prevNumber != nil && compareVersionNumbers(e.number, prevNumber.number) <= 0 {
+					me.add(e.path+".number", errorf(CodeVersionOrder, "version number %s must be greater than %s of %s", e.number, prevNumber.number, prevNumber.path))
+				}
+				prevNumber = e
+			}
+		}
+		if !e.date.IsZero() {
+			if prevDate != nil && e.date.Before(prevDate.date) {
+				me.add(e.path+".date", errorf(CodeDateOrder, "date %s is before %s of %s", e.date.Format(dateLayout), prevDate.date.Format(dateLayout), prevDate.path))
+			}
+			prevDate = e
+		}
+	}
+	return me.ToError()
+}
+
+// findVersion returns the entry with the given number, compared
+// numerically so "1.0" matches "1.00".
+func findVersion(entries []historyEntry, number string) *historyEntry {
+	for i := range entries {
+		if entries[i].number != "" && compareVersionNumbers(entries[i].number, number) == 0 {
+			return &entries[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package pkg
+
+import "testing"
+
+func TestValidateHistory(t *testing.T) {
+	runValidationTests(t, []validationTest{
+		{
+			name: "consistent",
+			doc: "ci:\n" +
+				"  versions:\n  - {number: '1.0', date: 01.02.2024}\n  - {number: '1.1', date: 01.03.2024}\n  - {number: '1.10', date: 01.03.2024}\n" +
+				"  audit-versions:\n  - {number: '1.1', date: 02.03.2024}\n" +
+				"  release-versions:\n  - {number: '1.1', date: 05.03.2024}\n",
+		},
+		{
+			name: "duplicate number",
+			doc:  "ci:\n  versions:\n  - {number: '1.0'}\n  - {number: '1.00'}\n",
+			want: []string{"ci.versions[1].number E_VERSION_DUPLICATE"},
+		},
+		{
+			name: "decreasing number",
+			doc:  "ci:\n  versions:\n  - {number: '1.2'}\n  - {number: '1.1'}\n",
+			want: []string{"ci.versions[1].number E_VERSION_ORDER"},
+		},
+		{
+			name: "decreasing date",
+			doc:  "ci:\n  versions:\n  - {number: '1.0', date: 02.01.2024}\n  - {number: '1.1', date: 01.01.2024}\n",
+			want: []string{"ci.versions[1].date E_DATE_ORDER"},
+		},
+		{
+			name: "lists are ordered on their own",
+			doc:  "ci:\n  versions:\n  - {number: '2.0'}\n  audit-versions:\n  - {number: '1.0', date: 01.01.2024}\n  release-versions:\n  - {number: '2.0', date: 01.01.2023}\n",
+		},
+		{
+			name: "release of unknown version",
+			doc:  "ci:\n  versions:\n  - {number: '1.0'}\n  release-versions:\n  - {number: '1.1'}\n",
+			want: []string{"ci.release_versions[0].number E_RELEASE_UNKNOWN_VERSION"},
+		},
+		{
+			name: "audit before version",
+			doc:  "ci:\n  versions:\n  - {number: '1.0', date: 10.01.2024}\n  audit-versions:\n  - {number: '1.0', date: 09.01.2024}\n",
+			want: []string{"ci.audit_versions[0].date E_AUDIT_BEFORE_VERSION"},
+		},
+		{
+			name: "invalid entries are only reported once",
+			doc:  "ci:\n  versions:\n  - {number: '1.0', date: 2024-01-01}\n  - {number: x, date: 01.01.2024}\n",
+			want: []string{"ci.versions[0].date E_DATE_FORMAT", "ci.versions[1].number E_VERSION_FORMAT"},
+		},
+	})
+}